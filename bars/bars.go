@@ -0,0 +1,511 @@
+// Go ddfplus API Bars
+//
+// Copyright 2019 Barchart.com, Inc. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the GNU license
+// available at https://github.com/barchart/go-ddfplus-api/blob/master/LICENSE.
+
+// Package bars builds OHLCV bars from ddfplus trades: time bars aligned to
+// the exchange session, daily bars, tick bars and volume bars.
+package bars
+
+import (
+	ddf "barchart/go-ddfpus-api/src"
+	"fmt"
+	"sync"
+	"time"
+)
+
+type Kind int
+
+const (
+	Time   Kind = iota // bars of Spec.Interval
+	Daily              // one bar per session
+	Ticks              // bars of Spec.Count trades
+	Volume             // bars of Spec.Count contracts
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Time:
+		return "time"
+	case Daily:
+		return "daily"
+	case Ticks:
+		return "ticks"
+	case Volume:
+		return "volume"
+	}
+
+	return "unknown"
+}
+
+// Spec describes one kind of bar.
+type Spec struct {
+	Kind     Kind
+	Interval time.Duration
+	Count    int64
+}
+
+func TimeBars(interval time.Duration) Spec {
+	return Spec{Kind: Time, Interval: interval}
+}
+
+func DailyBars() Spec {
+	return Spec{Kind: Daily}
+}
+
+func TickBars(trades int64) Spec {
+	return Spec{Kind: Ticks, Count: trades}
+}
+
+func VolumeBars(volume int64) Spec {
+	return Spec{Kind: Volume, Count: volume}
+}
+
+func (s Spec) String() string {
+	switch s.Kind {
+	case Time:
+		return s.Interval.String()
+	case Daily:
+		return "daily"
+	case Ticks:
+		return fmt.Sprintf("%d ticks", s.Count)
+	case Volume:
+		return fmt.Sprintf("%d volume", s.Count)
+	}
+
+	return "unknown"
+}
+
+func (s Spec) validate() error {
+	switch s.Kind {
+	case Time:
+		if s.Interval <= 0 {
+			return fmt.Errorf("invalid interval %v", s.Interval)
+		}
+	case Daily:
+	case Ticks, Volume:
+		if s.Count <= 0 {
+			return fmt.Errorf("invalid count %d for %v bars", s.Count, s.Kind)
+		}
+	default:
+		return fmt.Errorf("unknown bar kind %d", s.Kind)
+	}
+
+	return nil
+}
+
+// FillPolicy decides what happens to time bars without trades.
+type FillPolicy int
+
+const (
+	// FillNone skips intervals without trades.
+	FillNone FillPolicy = iota
+
+	// FillPrevious closes a flat bar at the previous close, with no volume,
+	// for each interval without trades. Gaps are only filled within a
+	// session, never across a session break.
+	FillPrevious
+)
+
+type Bar struct {
+	Symbol string
+	Spec   Spec
+	Start  time.Time // zero for tick and volume bars until the first trade
+	End    time.Time // the end of the interval, or the last trade for tick and volume bars
+	Open   float64
+	High   float64
+	Low    float64
+	Close  float64
+	Volume int64
+	Trades int64
+	Filled bool // a FillPrevious bar without trades
+}
+
+type EventType int
+
+const (
+	BarUpdate EventType = iota // a trade changed the open bar
+	BarClose                   // the bar is complete
+)
+
+func (t EventType) String() string {
+	if t == BarClose {
+		return "close"
+	}
+
+	return "update"
+}
+
+type Event struct {
+	Type EventType
+	Bar  Bar
+}
+
+type Config struct {
+	Specs []Spec
+	Fill  FillPolicy
+
+	// SessionOpen is the time of day, in exchange time, that sessions open.
+	// Time bars are aligned to it, and daily bars run from one open to the
+	// next, such as from 17:00 to 17:00 the next day for CME Globex.
+	SessionOpen time.Duration
+}
+
+type seriesKey struct {
+	symbol string
+	spec   int
+}
+
+// series is the bar being built for one symbol and spec.
+type series struct {
+	bar   *Bar
+	close float64   // last close, for filling
+	next  time.Time // end of the last closed time bar
+}
+
+// Aggregator builds bars from trades. It can be fed directly with Trade and
+// Advance, or attached to a Connection with Connect or to a DB with
+// ConnectDB.
+type Aggregator struct {
+	config Config
+
+	mu        sync.Mutex
+	series    map[seriesKey]*series
+	listeners []chan Event
+}
+
+func New(config Config) (*Aggregator, error) {
+	for _, s := range config.Specs {
+		err := s.validate()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &Aggregator{
+		config: config,
+		series: make(map[seriesKey]*series),
+	}, nil
+}
+
+// Register sends ch every bar event.
+func (a *Aggregator) Register(ch chan Event) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, l := range a.listeners {
+		if l == ch {
+			return
+		}
+	}
+
+	a.listeners = append(a.listeners, ch)
+}
+
+// Connect feeds the aggregator with the trades of conn, and advances time
+// bars with its timestamp messages, so replays produce the same bars.
+func (a *Aggregator) Connect(conn *ddf.Connection) {
+	// The connection waits for each send to be received before dispatching
+	// the next message, so trades and timestamps are taken in feed order
+	// as long as one goroutine receives both
+	ch := make(chan ddf.Message)
+	ts := make(chan ddf.MessageTimestamp)
+	go func() {
+		for {
+			select {
+			case m := <-ch:
+				if tr, ok := m.(ddf.MessageTrade); ok {
+					a.send(a.Trade(tr))
+				}
+			case m := <-ts:
+				a.send(a.Advance(m.Timestamp))
+			}
+		}
+	}()
+	conn.RegisterMarketUpdateAll(ch)
+	conn.RegisterTimestamp(ts)
+}
+
+// ConnectDB feeds the aggregator with the trades of symbols as db applies
+// them, and advances time bars with the timestamps db processes.
+func (a *Aggregator) ConnectDB(db *ddf.DB, symbols []string) {
+	deltas := make(chan ddf.QuoteDelta)
+	ts := make(chan ddf.MessageTimestamp)
+	go func() {
+		for {
+			select {
+			case d := <-deltas:
+				if d.Type != ddf.Trade {
+					continue
+				}
+
+				cur := d.New.Data.CurrentSession
+				a.send(a.Trade(ddf.MessageTrade{
+					Symbol:    d.Symbol,
+					Trade:     cur.Last,
+					TradeSize: cur.LastSize,
+					Timestamp: cur.TradeTime,
+				}))
+			case m := <-ts:
+				a.send(a.Advance(m.Timestamp))
+			}
+		}
+	}()
+	db.RegisterDelta(symbols, ddf.FieldTrade|ddf.FieldNumTrades, deltas)
+	db.RegisterTimestamp(ts)
+}
+
+func (a *Aggregator) send(events []Event) {
+	if len(events) == 0 {
+		return
+	}
+
+	a.mu.Lock()
+	listeners := a.listeners
+	a.mu.Unlock()
+
+	for _, e := range events {
+		for _, ch := range listeners {
+			ch <- e
+		}
+	}
+}
+
+// Current returns the open bar of symbol for the spec at index i of the
+// config.
+func (a *Aggregator) Current(symbol string, i int) (Bar, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	s := a.series[seriesKey{symbol, i}]
+	if s == nil || s.bar == nil {
+		return Bar{}, false
+	}
+
+	return *s.bar, true
+}
+
+// sessionStart returns the open of the session t is in.
+func (a *Aggregator) sessionStart(t time.Time) time.Time {
+	t = t.In(ddf.Location())
+
+	y, m, d := t.Date()
+	start := a.openOn(y, m, d, t.Location())
+	if start.After(t) {
+		start = a.openOn(y, m, d-1, t.Location())
+	}
+
+	return start
+}
+
+func (a *Aggregator) sessionEnd(start time.Time) time.Time {
+	y, m, d := start.Date()
+	return a.openOn(y, m, d+1, start.Location())
+}
+
+// openOn returns the session open of a day. It is built from the wall clock,
+// as adding SessionOpen to midnight is an hour off on DST days.
+func (a *Aggregator) openOn(y int, m time.Month, d int, loc *time.Location) time.Time {
+	open := a.config.SessionOpen
+	hour := int(open / time.Hour)
+	min := int(open % time.Hour / time.Minute)
+	sec := int(open % time.Minute / time.Second)
+
+	return time.Date(y, m, d, hour, min, sec, int(open%time.Second), loc)
+}
+
+// bucket returns the interval of spec that t falls in.
+func (a *Aggregator) bucket(spec Spec, t time.Time) (time.Time, time.Time) {
+	start := a.sessionStart(t)
+	end := a.sessionEnd(start)
+	if spec.Kind == Daily {
+		return start, end
+	}
+
+	n := t.Sub(start) / spec.Interval
+	bucketStart := start.Add(n * spec.Interval)
+	bucketEnd := bucketStart.Add(spec.Interval)
+	if bucketEnd.After(end) {
+		bucketEnd = end
+	}
+
+	return bucketStart, bucketEnd
+}
+
+func newBar(symbol string, spec Spec, price float64) *Bar {
+	return &Bar{
+		Symbol: symbol,
+		Spec:   spec,
+		Open:   price,
+		High:   price,
+		Low:    price,
+		Close:  price,
+	}
+}
+
+func (b *Bar) add(price float64, size int64, t time.Time) {
+	if price > b.High {
+		b.High = price
+	}
+	if price < b.Low {
+		b.Low = price
+	}
+
+	b.Close = price
+	b.Volume += size
+	b.Trades++
+	if b.Start.IsZero() {
+		b.Start = t
+	}
+	if b.End.IsZero() || t.After(b.End) {
+		b.End = t
+	}
+}
+
+// Trade adds a trade to every spec, returning the events it causes.
+func (a *Aggregator) Trade(tr ddf.MessageTrade) []Event {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var events []Event
+	for i, spec := range a.config.Specs {
+		key := seriesKey{tr.Symbol, i}
+		s := a.series[key]
+		if s == nil {
+			s = &series{}
+			a.series[key] = s
+		}
+
+		switch spec.Kind {
+		case Time, Daily:
+			events = a.timeTrade(events, s, spec, tr)
+		case Ticks, Volume:
+			events = countTrade(events, s, spec, tr)
+		}
+	}
+
+	return events
+}
+
+func (a *Aggregator) timeTrade(events []Event, s *series, spec Spec, tr ddf.MessageTrade) []Event {
+	start, end := a.bucket(spec, tr.Timestamp)
+
+	if s.bar != nil && !s.bar.Start.Equal(start) {
+		if start.Before(s.bar.Start) {
+			// A late trade for a closed bar is dropped
+			return events
+		}
+
+		events = a.closeTime(events, s, start)
+	}
+
+	if s.bar == nil {
+		if !s.next.IsZero() && start.Before(s.next) {
+			return events
+		}
+
+		events = a.fill(events, s, spec, tr.Symbol, start)
+
+		s.bar = newBar(tr.Symbol, spec, tr.Trade)
+		s.bar.Start, s.bar.End = start, end
+	}
+
+	s.bar.add(tr.Trade, tr.TradeSize, tr.Timestamp)
+	s.bar.End = end
+
+	return append(events, Event{Type: BarUpdate, Bar: *s.bar})
+}
+
+// closeTime closes the open time bar, then fills up to until.
+func (a *Aggregator) closeTime(events []Event, s *series, until time.Time) []Event {
+	b := s.bar
+	s.bar = nil
+	s.close = b.Close
+	s.next = b.End
+	events = append(events, Event{Type: BarClose, Bar: *b})
+
+	return a.fill(events, s, b.Spec, b.Symbol, until)
+}
+
+// fill closes flat bars for the intervals after the last closed bar that end
+// by until, within the session of the last closed bar.
+func (a *Aggregator) fill(events []Event, s *series, spec Spec, symbol string, until time.Time) []Event {
+	if a.config.Fill != FillPrevious || s.next.IsZero() || spec.Kind != Time {
+		return events
+	}
+
+	sessionEnd := a.sessionEnd(a.sessionStart(s.next.Add(-time.Nanosecond)))
+	for s.next.Before(sessionEnd) {
+		start, end := a.bucket(spec, s.next)
+		if end.After(until) {
+			break
+		}
+
+		b := newBar(symbol, spec, s.close)
+		b.Start, b.End, b.Filled = start, end, true
+		events = append(events, Event{Type: BarClose, Bar: *b})
+		s.next = end
+	}
+
+	return events
+}
+
+// countTrade adds a trade to a tick or volume bar, splitting its size across
+// bars when it completes more than one volume bar.
+func countTrade(events []Event, s *series, spec Spec, tr ddf.MessageTrade) []Event {
+	size := tr.TradeSize
+	for first := true; first || size > 0; first = false {
+		if s.bar == nil {
+			s.bar = newBar(tr.Symbol, spec, tr.Trade)
+		}
+
+		part := size
+		if spec.Kind == Volume && s.bar.Volume+part > spec.Count {
+			part = spec.Count - s.bar.Volume
+		}
+
+		s.bar.add(tr.Trade, part, tr.Timestamp)
+		size -= part
+
+		full := s.bar.Trades >= spec.Count
+		if spec.Kind == Volume {
+			full = s.bar.Volume >= spec.Count
+		}
+
+		if !full {
+			return append(events, Event{Type: BarUpdate, Bar: *s.bar})
+		}
+
+		b := s.bar
+		s.bar = nil
+		s.close = b.Close
+		events = append(events, Event{Type: BarClose, Bar: *b})
+	}
+
+	return events
+}
+
+// Advance closes the time bars that end at or before now, such as on a
+// timestamp message, rather than waiting for the next trade.
+func (a *Aggregator) Advance(now time.Time) []Event {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var events []Event
+	for key, s := range a.series {
+		spec := a.config.Specs[key.spec]
+		if spec.Kind != Time && spec.Kind != Daily {
+			continue
+		}
+
+		if s.bar != nil && !s.bar.End.After(now) {
+			events = a.closeTime(events, s, now)
+		} else if s.bar == nil {
+			events = a.fill(events, s, spec, key.symbol, now)
+		}
+	}
+
+	return events
+}
@@ -0,0 +1,211 @@
+// Go ddfplus API Bars
+//
+// Copyright 2019 Barchart.com, Inc. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the GNU license
+// available at https://github.com/barchart/go-ddfplus-api/blob/master/LICENSE.
+package bars
+
+import (
+	ddf "barchart/go-ddfpus-api/src"
+	"testing"
+	"time"
+)
+
+func at(hour, min, sec int) time.Time {
+	return time.Date(2019, 11, 6, hour, min, sec, 0, ddf.Location())
+}
+
+func trade(t time.Time, price float64, size int64) ddf.MessageTrade {
+	return ddf.MessageTrade{Symbol: "ESZ9", Trade: price, TradeSize: size, Timestamp: t}
+}
+
+func closes(events []Event) []Bar {
+	var bars []Bar
+	for _, e := range events {
+		if e.Type == BarClose {
+			bars = append(bars, e.Bar)
+		}
+	}
+
+	return bars
+}
+
+func TestTimeBars(t *testing.T) {
+	a, err := New(Config{Specs: []Spec{TimeBars(time.Minute)}, Fill: FillPrevious, SessionOpen: 17 * time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a.Trade(trade(at(9, 30, 5), 3000, 2))
+	a.Trade(trade(at(9, 30, 40), 3001.25, 1))
+	events := a.Trade(trade(at(9, 30, 59), 2999.5, 4))
+	if len(events) != 1 || events[0].Type != BarUpdate {
+		t.Fatalf("unexpected events %+v", events)
+	}
+
+	b := events[0].Bar
+	if !b.Start.Equal(at(9, 30, 0)) || !b.End.Equal(at(9, 31, 0)) {
+		t.Errorf("unexpected bar interval %v to %v", b.Start, b.End)
+	}
+
+	if b.Open != 3000 || b.High != 3001.25 || b.Low != 2999.5 || b.Close != 2999.5 || b.Volume != 7 || b.Trades != 3 {
+		t.Errorf("unexpected bar %+v", b)
+	}
+
+	// Two minutes without trades are filled at the previous close
+	bars := closes(a.Trade(trade(at(9, 33, 10), 3002, 1)))
+	if len(bars) != 3 || bars[0].Filled || !bars[1].Filled || !bars[2].Filled {
+		t.Fatalf("unexpected closed bars %+v", bars)
+	}
+
+	if bars[2].Close != 2999.5 || bars[2].Volume != 0 || !bars[2].Start.Equal(at(9, 32, 0)) {
+		t.Errorf("unexpected filled bar %+v", bars[2])
+	}
+
+	// Timestamps close bars without waiting for a trade
+	if bars := closes(a.Advance(at(9, 33, 59))); len(bars) != 0 {
+		t.Errorf("closed a bar early %+v", bars)
+	}
+
+	bars = closes(a.Advance(at(9, 35, 0)))
+	if len(bars) != 2 || bars[0].Close != 3002 || !bars[1].Filled || !bars[1].End.Equal(at(9, 35, 0)) {
+		t.Errorf("unexpected bars on advance %+v", bars)
+	}
+
+	// Gaps are filled up to the end of the session, not into the next one
+	bars = closes(a.Trade(trade(at(17, 5, 30), 3003, 1)))
+	if len(bars) != 445 || !bars[444].End.Equal(at(17, 0, 0)) {
+		t.Errorf("expected bars up to the session end, got %d ending %v", len(bars), bars[len(bars)-1].End)
+	}
+
+	bars = closes(a.Advance(at(17, 6, 0)))
+	if len(bars) != 1 || !bars[0].Start.Equal(at(17, 5, 0)) {
+		t.Errorf("unexpected bars after the break %+v", bars)
+	}
+}
+
+func TestTimeBarsNoFill(t *testing.T) {
+	a, _ := New(Config{Specs: []Spec{TimeBars(5 * time.Minute)}})
+
+	a.Trade(trade(at(9, 31, 0), 3000, 1))
+	bars := closes(a.Trade(trade(at(9, 52, 0), 3001, 1)))
+	if len(bars) != 1 || !bars[0].Start.Equal(at(9, 30, 0)) || !bars[0].End.Equal(at(9, 35, 0)) {
+		t.Errorf("unexpected bars %+v", bars)
+	}
+}
+
+func TestDailyBars(t *testing.T) {
+	a, _ := New(Config{Specs: []Spec{DailyBars()}, SessionOpen: 17 * time.Hour})
+
+	a.Trade(trade(at(16, 59, 0), 3000, 1))
+	bars := closes(a.Trade(trade(at(17, 0, 1), 3001, 1)))
+	if len(bars) != 1 {
+		t.Fatalf("expected the session to close, got %+v", bars)
+	}
+
+	if !bars[0].Start.Equal(at(17, 0, 0).AddDate(0, 0, -1)) || !bars[0].End.Equal(at(17, 0, 0)) {
+		t.Errorf("unexpected session %v to %v", bars[0].Start, bars[0].End)
+	}
+}
+
+func TestSessionDST(t *testing.T) {
+	a, _ := New(Config{Specs: []Spec{DailyBars()}, SessionOpen: 17 * time.Hour})
+	loc := ddf.Location()
+
+	for _, c := range []struct {
+		trade      time.Time
+		start, end time.Time
+	}{
+		// Spring forward on 2019-03-10
+		{
+			time.Date(2019, 3, 10, 17, 30, 0, 0, loc),
+			time.Date(2019, 3, 10, 17, 0, 0, 0, loc),
+			time.Date(2019, 3, 11, 17, 0, 0, 0, loc),
+		},
+		{
+			time.Date(2019, 3, 10, 16, 30, 0, 0, loc),
+			time.Date(2019, 3, 9, 17, 0, 0, 0, loc),
+			time.Date(2019, 3, 10, 17, 0, 0, 0, loc),
+		},
+		// Fall back on 2019-11-03
+		{
+			time.Date(2019, 11, 3, 16, 30, 0, 0, loc),
+			time.Date(2019, 11, 2, 17, 0, 0, 0, loc),
+			time.Date(2019, 11, 3, 17, 0, 0, 0, loc),
+		},
+		{
+			time.Date(2019, 11, 3, 17, 0, 0, 0, loc),
+			time.Date(2019, 11, 3, 17, 0, 0, 0, loc),
+			time.Date(2019, 11, 4, 17, 0, 0, 0, loc),
+		},
+	} {
+		start, end := a.bucket(DailyBars(), c.trade)
+		if !start.Equal(c.start) || !end.Equal(c.end) {
+			t.Errorf("%v in session %v to %v, expected %v to %v", c.trade, start, end, c.start, c.end)
+		}
+	}
+}
+
+func TestTickAndVolumeBars(t *testing.T) {
+	a, _ := New(Config{Specs: []Spec{TickBars(2), VolumeBars(10)}})
+
+	a.Trade(trade(at(9, 30, 0), 3000, 4))
+	events := a.Trade(trade(at(9, 30, 1), 3001, 25))
+
+	var ticks, volume []Bar
+	for _, b := range closes(events) {
+		if b.Spec.Kind == Ticks {
+			ticks = append(ticks, b)
+		} else {
+			volume = append(volume, b)
+		}
+	}
+
+	if len(ticks) != 1 || ticks[0].Trades != 2 || ticks[0].Volume != 29 {
+		t.Errorf("unexpected tick bars %+v", ticks)
+	}
+
+	if len(volume) != 2 || volume[0].Volume != 10 || volume[0].Open != 3000 || volume[1].Volume != 10 || volume[1].Open != 3001 {
+		t.Errorf("unexpected volume bars %+v", volume)
+	}
+
+	b, ok := a.Current("ESZ9", 1)
+	if !ok || b.Volume != 9 {
+		t.Errorf("unexpected open volume bar %+v", b)
+	}
+}
+
+func TestConnectDB(t *testing.T) {
+	a, _ := New(Config{Specs: []Spec{TimeBars(time.Minute)}, SessionOpen: 17 * time.Hour})
+	events := make(chan Event, 16)
+	a.Register(events)
+
+	db := ddf.InitDB()
+	a.ConnectDB(db, []string{"ESZ9"})
+
+	// The refresh and the CLZ9 trade are not trades of ESZ9
+	db.Process(ddf.MessageRefresh{Symbol: "ESZ9", BaseCode: "A", CurrentSession: ddf.RefreshSession{Last: 2999, TradeTime: at(9, 29, 0)}})
+	db.Process(ddf.MessageRefresh{Symbol: "CLZ9", BaseCode: "A"})
+	db.Process(trade(at(9, 30, 5), 3000, 2))
+	db.Process(ddf.MessageTrade{Symbol: "CLZ9", Trade: 56.02, TradeSize: 1, Timestamp: at(9, 30, 10)})
+	db.Process(trade(at(9, 30, 40), 3001, 1))
+	db.Process(ddf.MessageTimestamp{Timestamp: at(9, 31, 0)})
+
+	for {
+		select {
+		case e := <-events:
+			if e.Type != BarClose {
+				continue
+			}
+
+			b := e.Bar
+			if b.Symbol != "ESZ9" || b.Open != 3000 || b.Close != 3001 || b.Volume != 3 || b.Trades != 2 || !b.End.Equal(at(9, 31, 0)) {
+				t.Errorf("unexpected bar %+v", b)
+			}
+			return
+		case <-time.After(5 * time.Second):
+			t.Fatal("no bar closed")
+		}
+	}
+}
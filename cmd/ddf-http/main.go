@@ -0,0 +1,144 @@
+// Go ddfplus API ddf-http
+//
+// Copyright 2019 Barchart.com, Inc. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the GNU license
+// available at https://github.com/barchart/go-ddfplus-api/blob/master/LICENSE.
+
+// ddf-http serves ddfplus quote snapshots as JSON over HTTP, and streams
+// quote updates under /stream. See ddf.HTTPHandler and ddf.StreamHandler for
+// the endpoints. With -snapshot, the quotes are saved periodically and on
+// exit, and served as stale on the next start until the feed refreshes them.
+package main
+
+import (
+	ddf "barchart/go-ddfpus-api/src"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+func main() {
+	var user = flag.String("u", "", "Username")
+	var pass = flag.String("p", "", "password")
+	var server = flag.String("server", "", "ddfplus server (default from the user's settings)")
+	var symbols = flag.String("s", "", "Comma separated symbols to subscribe to at startup")
+	var auto = flag.Bool("auto", true, "Subscribe to symbols on their first request")
+	var maxSubs = flag.Int("max-subs", 1000, "Most symbols auto subscribed to, per handler (0 for no limit)")
+	var roots = flag.String("roots", "", "Comma separated roots, such as ES,CL, that may be auto subscribed to (default any)")
+	var addr = flag.String("addr", ":8080", "HTTP listen address")
+	var maxAge = flag.Duration("max-age", 30*time.Second, "Feed age after which /health reports stale")
+	var interval = flag.Duration("interval", 250*time.Millisecond, "Default stream throttling interval")
+	var origins = flag.String("origins", "", "Comma separated origins, besides the server's own, allowed to open WebSocket streams")
+	var snapshot = flag.String("snapshot", "", "Snapshot file to load at startup and checkpoint to")
+	var checkpoint = flag.Duration("checkpoint", time.Minute, "Interval between snapshot checkpoints")
+	flag.Parse()
+
+	credentials := &ddf.Credentials{
+		Username: *user,
+		Password: *pass,
+	}
+
+	var (
+		conn *ddf.Connection
+		err  error
+	)
+	if *server != "" {
+		conn = ddf.NewConnectionTo(*server, credentials)
+	} else {
+		conn, err = ddf.NewConnection(credentials)
+		if err != nil {
+			log.Fatalf("Error creating connection. %v", err)
+		}
+	}
+
+	db := ddf.InitDB()
+
+	var subscribe []string
+	if *symbols != "" {
+		subscribe = strings.Split(*symbols, ",")
+	}
+
+	if *snapshot != "" {
+		err = db.LoadSnapshotFile(*snapshot)
+		if err != nil && !os.IsNotExist(err) {
+			log.Fatalf("Error loading snapshot. %v", err)
+		}
+
+		// Serve the last known quotes while their refreshes arrive
+		subscribe = append(subscribe, db.Symbols()...)
+
+		stop := db.Checkpoint(*snapshot, *checkpoint)
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-sig
+			stop()
+			os.Exit(0)
+		}()
+	}
+
+	db.Connect(conn)
+
+	if len(subscribe) > 0 {
+		ch := make(chan ddf.Message)
+		go func() {
+			for range ch {
+			}
+		}()
+		conn.RegisterMarketUpdate(subscribe, ch)
+	}
+
+	var allow func(string) bool
+	if *roots != "" {
+		allowed := make(map[string]bool)
+		for _, r := range strings.Split(*roots, ",") {
+			allowed[strings.TrimSpace(r)] = true
+		}
+		allow = func(symbol string) bool {
+			sym, _ := ddf.ParseSymbol(symbol)
+			return allowed[sym.Root]
+		}
+	}
+
+	handler := ddf.NewHTTPHandler(db)
+	handler.MaxAge = *maxAge
+	handler.MaxSubscriptions = *maxSubs
+	handler.AllowSymbol = allow
+	if *auto {
+		handler.AutoSubscribe(conn)
+	}
+
+	stream := ddf.NewStreamHandler(db)
+	stream.Interval = *interval
+	stream.MaxSubscriptions = *maxSubs
+	stream.AllowSymbol = allow
+	if *origins != "" {
+		allowed := make(map[string]bool)
+		for _, o := range strings.Split(*origins, ",") {
+			allowed[strings.TrimSpace(o)] = true
+		}
+		stream.CheckOrigin = func(r *http.Request) bool {
+			return allowed[r.Header.Get("Origin")] || ddf.SameOrigin(r)
+		}
+	}
+	if *auto {
+		stream.AutoSubscribe(conn)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/stream", stream)
+	mux.Handle("/", handler)
+
+	go func() {
+		log.Fatal(http.ListenAndServe(*addr, mux))
+	}()
+
+	conn.SetReconnect(true)
+	conn.Start()
+}
@@ -0,0 +1,227 @@
+// Go ddfplus API ddf-proxy
+//
+// Copyright 2019 Barchart.com, Inc. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the GNU license
+// available at https://github.com/barchart/go-ddfplus-api/blob/master/LICENSE.
+
+// ddf-proxy shares one upstream ddfplus login among many jerq clients.
+//
+// Clients log in against a local user file, with one user per line as
+// "username:password" or "username:sha256:<hex digest of password>". Lines
+// starting with '#' are ignored. Upstream subscriptions are reference counted
+// across clients, and a client subscribing to a symbol that is already
+// streaming is sent the cached quote as a refresh. Quotes, depth, XML and
+// messages the parser does not understand are all relayed.
+package main
+
+import (
+	ddf "barchart/go-ddfpus-api/src"
+	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+)
+
+type user struct {
+	password string
+	hashed   bool
+}
+
+func loadUsers(path string) (map[string]user, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	users := make(map[string]user)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		parts := strings.SplitN(line, ":", 3)
+		switch {
+		case len(parts) == 3 && parts[1] == "sha256":
+			users[parts[0]] = user{password: strings.ToLower(parts[2]), hashed: true}
+		case len(parts) >= 2:
+			users[parts[0]] = user{password: strings.Join(parts[1:], ":")}
+		default:
+			return nil, fmt.Errorf("%s:%d: expected username:password", path, n)
+		}
+	}
+
+	return users, scanner.Err()
+}
+
+func (u user) check(password string) bool {
+	if u.hashed {
+		sum := sha256.Sum256([]byte(password))
+		password = hex.EncodeToString(sum[:])
+	}
+
+	return subtle.ConstantTimeCompare([]byte(u.password), []byte(password)) == 1
+}
+
+type proxy struct {
+	conn    *ddf.Connection
+	db      *ddf.DB
+	server  *ddf.Server
+	updates chan ddf.Message
+
+	// mu also covers feeding the DB and publishing in relay, so the DB
+	// always matches what clients have been sent
+	mu   sync.Mutex
+	refs map[string]int
+}
+
+// subscribe adds a reference to each symbol. Symbols new to the proxy are
+// subscribed upstream, whose refresh then reaches the client through
+// Publish. The others are answered from the DB, or, if their refresh has not
+// arrived yet, also get it through Publish, as the client is already
+// subscribed when relay processes it.
+func (p *proxy) subscribe(c *ddf.ServerClient, symbols []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var added []string
+	for _, s := range symbols {
+		p.refs[s]++
+		if p.refs[s] == 1 {
+			added = append(added, s)
+			continue
+		}
+
+		if q := p.db.GetQuote(s); q != nil {
+			err := c.Send(q.Refresh())
+			if err != nil {
+				log.Printf("Error sending refresh for %s. %v", s, err)
+			}
+		}
+	}
+
+	if len(added) > 0 {
+		p.conn.RegisterMarketUpdate(added, p.updates)
+		p.conn.RegisterMarketDepth(added, p.updates)
+	}
+}
+
+// unsubscribe drops a reference to each symbol, stopping it upstream once no
+// client is left.
+func (p *proxy) unsubscribe(c *ddf.ServerClient, symbols []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var removed []string
+	for _, s := range symbols {
+		if p.refs[s] == 0 {
+			continue
+		}
+
+		p.refs[s]--
+		if p.refs[s] == 0 {
+			delete(p.refs, s)
+			removed = append(removed, s)
+		}
+	}
+
+	if len(removed) > 0 {
+		p.conn.UnregisterMarketUpdate(removed, p.updates)
+		p.conn.UnregisterMarketDepth(removed, p.updates)
+	}
+}
+
+// relay feeds every upstream message to the DB and publishes it, in feed
+// order.
+func (p *proxy) relay(timestamps chan ddf.MessageTimestamp, raw chan ddf.MessageRaw) {
+	for {
+		var m ddf.Message
+		select {
+		case m = <-p.updates:
+		case m = <-timestamps:
+		case m = <-raw:
+		}
+
+		p.mu.Lock()
+		err := p.db.Process(m)
+		if err != nil {
+			log.Printf("Error processing message. %v", err)
+		}
+
+		err = p.server.Publish(m)
+		if err != nil {
+			log.Printf("Error publishing message. %v", err)
+		}
+		p.mu.Unlock()
+	}
+}
+
+func main() {
+	var username = flag.String("u", "", "Upstream username")
+	var password = flag.String("p", "", "Upstream password")
+	var upstream = flag.String("upstream", "", "Upstream server (default from the user's settings)")
+	var addr = flag.String("addr", ":7500", "Address to listen on")
+	var usersFile = flag.String("users", "", "File of client logins")
+	flag.Parse()
+
+	if *usersFile == "" {
+		log.Fatal("No users file given.")
+	}
+
+	users, err := loadUsers(*usersFile)
+	if err != nil {
+		log.Fatalf("Error loading users. %v", err)
+	}
+
+	credentials := &ddf.Credentials{
+		Username: *username,
+		Password: *password,
+	}
+
+	var conn *ddf.Connection
+	if *upstream != "" {
+		conn = ddf.NewConnectionTo(*upstream, credentials)
+	} else {
+		conn, err = ddf.NewConnection(credentials)
+		if err != nil {
+			log.Fatalf("Error creating connection. %v", err)
+		}
+	}
+
+	p := &proxy{
+		conn:    conn,
+		db:      ddf.InitDB(),
+		server:  ddf.NewServer(),
+		updates: make(chan ddf.Message, 1024),
+		refs:    make(map[string]int),
+	}
+
+	p.server.Authenticate = func(username string, password string) bool {
+		u, ok := users[username]
+		return ok && u.check(password)
+	}
+	p.server.OnSubscribe = p.subscribe
+	p.server.OnUnsubscribe = p.unsubscribe
+
+	timestamps := make(chan ddf.MessageTimestamp)
+	raw := make(chan ddf.MessageRaw)
+	conn.RegisterTimestamp(timestamps)
+	conn.RegisterRaw(raw)
+	go p.relay(timestamps, raw)
+
+	go func() {
+		log.Fatal(p.server.ListenAndServe(*addr))
+	}()
+
+	conn.SetReconnect(true)
+	conn.Start()
+}
@@ -0,0 +1,97 @@
+// Go ddfplus API ddf-record
+//
+// Copyright 2019 Barchart.com, Inc. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the GNU license
+// available at https://github.com/barchart/go-ddfplus-api/blob/master/LICENSE.
+
+// ddf-record captures every frame of a ddfplus session to disk for replay.
+package main
+
+import (
+	ddf "barchart/go-ddfpus-api/src"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+)
+
+func main() {
+	var user = flag.String("u", "", "Username")
+	var pass = flag.String("p", "", "password")
+	var symbols = flag.String("s", "", "Comma separated symbols")
+	var dir = flag.String("dir", ".", "Output directory")
+	var prefix = flag.String("prefix", "ddf", "File name prefix")
+	var maxSize = flag.Int64("max-size", 0, "Rotate after this many bytes (0 for no limit)")
+	var daily = flag.Bool("daily", true, "Rotate when the trading day changes")
+	var dayOffset = flag.Duration("day-offset", 7*time.Hour, "Offset added to exchange time to find the trading day")
+	var compress = flag.Bool("gzip", false, "Compress files with gzip")
+	flag.Parse()
+
+	if *symbols == "" {
+		log.Fatal("No symbols given.")
+	}
+
+	options := ddf.RecorderOptions{
+		Dir:         *dir,
+		Prefix:      *prefix,
+		MaxSize:     *maxSize,
+		RotateDaily: *daily,
+		DayOffset:   *dayOffset,
+	}
+	if *compress {
+		options.Compression = ddf.CompressGzip
+	}
+
+	rec, err := ddf.NewRecorder(options)
+	if err != nil {
+		log.Fatalf("Error creating recorder. %v", err)
+	}
+
+	conn, err := ddf.NewConnection(&ddf.Credentials{
+		Username: *user,
+		Password: *pass,
+	})
+	if err != nil {
+		log.Fatalf("Error creating connection. %v", err)
+	}
+
+	// The connection only subscribes to symbols that have a listener, and
+	// the frames are recorded before they are parsed, so drain the channel
+	ch := make(chan ddf.Message)
+	go func() {
+		for range ch {
+		}
+	}()
+	conn.RegisterMarketUpdate(strings.Split(*symbols, ","), ch)
+	conn.Record(rec)
+
+	go func() {
+		for range time.Tick(time.Second) {
+			err := rec.Flush()
+			if err != nil {
+				log.Printf("Error flushing recording. %v", err)
+			}
+		}
+	}()
+
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt)
+		<-sig
+		err := rec.Close()
+		if err != nil {
+			log.Printf("Error closing recording. %v", err)
+		}
+		os.Exit(0)
+	}()
+
+	conn.Start()
+
+	err = rec.Close()
+	if err != nil {
+		log.Printf("Error closing recording. %v", err)
+	}
+}
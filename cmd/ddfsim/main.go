@@ -0,0 +1,108 @@
+// Go ddfplus API ddfsim
+//
+// Copyright 2019 Barchart.com, Inc. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the GNU license
+// available at https://github.com/barchart/go-ddfplus-api/blob/master/LICENSE.
+
+// ddfsim serves synthetic market data over the jerq protocol. Point a
+// Connection at it with ddf.NewConnectionTo.
+//
+// Symbols are given as SYMBOL or SYMBOL:basecode:tickincrement:price, for
+// example "ESZ9,CLZ9,XYZ:A:5:42.50".
+package main
+
+import (
+	"barchart/go-ddfpus-api/ddfsim"
+	"flag"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Contract details for a few well known roots, used when a symbol is given
+// without them.
+var roots = map[string]ddfsim.Instrument{
+	"ES": {Name: "E-Mini S&P 500", Exchange: "CME", DDFExchange: "M", BaseCode: "A", TickIncrement: 25, PointValue: 50, Price: 3000},
+	"NQ": {Name: "E-Mini Nasdaq 100", Exchange: "CME", DDFExchange: "M", BaseCode: "A", TickIncrement: 25, PointValue: 20, Price: 8000},
+	"CL": {Name: "Crude Oil", Exchange: "NYMEX", DDFExchange: "E", BaseCode: "A", TickIncrement: 1, PointValue: 1000, Price: 56},
+	"GC": {Name: "Gold", Exchange: "COMEX", DDFExchange: "J", BaseCode: "A", TickIncrement: 10, PointValue: 100, Price: 1500},
+	"ZC": {Name: "Corn", Exchange: "CBOT", DDFExchange: "B", BaseCode: "2", TickIncrement: 2, PointValue: 50, Price: 387},
+	"ZN": {Name: "10-Year T-Note", Exchange: "CBOT", DDFExchange: "B", BaseCode: "5", TickIncrement: 1, PointValue: 1000, Price: 129},
+}
+
+func parseInstrument(spec string) (ddfsim.Instrument, error) {
+	parts := strings.Split(spec, ":")
+
+	var in ddfsim.Instrument
+	if len(parts) == 4 {
+		in.BaseCode = parts[1]
+		tick, err := strconv.Atoi(parts[2])
+		if err != nil {
+			return in, err
+		}
+		in.TickIncrement = tick
+
+		price, err := strconv.ParseFloat(parts[3], 64)
+		if err != nil {
+			return in, err
+		}
+		in.Price = price
+		in.PointValue = 1
+		in.DDFExchange = "Q"
+	} else {
+		root := strings.TrimRight(parts[0], "0123456789")
+		if len(root) > 1 {
+			root = root[:len(root)-1] // month code
+		}
+
+		if r, ok := roots[root]; ok {
+			in = r
+		} else {
+			in = ddfsim.Instrument{BaseCode: "A", TickIncrement: 1, PointValue: 1, Price: 100, DDFExchange: "Q"}
+		}
+	}
+
+	in.Symbol = parts[0]
+	if in.Name == "" {
+		in.Name = in.Symbol
+	}
+
+	return in, nil
+}
+
+func main() {
+	var addr = flag.String("addr", ":7500", "Listen address")
+	var symbols = flag.String("s", "ESZ9,NQZ9,CLZ9,GCZ9,ZCZ9,ZNZ9", "Comma separated symbols")
+	var rate = flag.Float64("rate", 10, "Messages per second per symbol")
+	var tradeRatio = flag.Float64("trade-ratio", 0.3, "Share of messages that are trades")
+	var session = flag.Duration("session", 0, "Session length (0 to stay open)")
+	var pause = flag.Duration("break", time.Minute, "Break between sessions")
+	var seed = flag.Int64("seed", time.Now().UnixNano(), "Random seed")
+	flag.Parse()
+
+	config := ddfsim.Config{
+		Rate:          *rate,
+		TradeRatio:    *tradeRatio,
+		SessionLength: *session,
+		BreakLength:   *pause,
+		Seed:          *seed,
+	}
+
+	for _, spec := range strings.Split(*symbols, ",") {
+		in, err := parseInstrument(spec)
+		if err != nil {
+			log.Fatalf("Invalid symbol \"%s\". %v", spec, err)
+		}
+		config.Instruments = append(config.Instruments, in)
+	}
+
+	sim, err := ddfsim.New(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("Serving %d symbols on %s", len(config.Instruments), *addr)
+	log.Fatal(sim.Serve(*addr, make(chan struct{})))
+}
@@ -0,0 +1,398 @@
+// Go ddfplus API Simulator
+//
+// Copyright 2019 Barchart.com, Inc. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the GNU license
+// available at https://github.com/barchart/go-ddfplus-api/blob/master/LICENSE.
+
+// Package ddfsim generates synthetic ddfplus market data and serves it over
+// the jerq protocol, for load and UI testing.
+package ddfsim
+
+import (
+	ddf "barchart/go-ddfpus-api/src"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+type Instrument struct {
+	Symbol        string
+	Name          string
+	Exchange      string
+	DDFExchange   string
+	BaseCode      string
+	TickIncrement int
+	PointValue    float64
+	Price         float64 // starting price
+}
+
+type Config struct {
+	Instruments []Instrument
+
+	// Rate is the number of bid/ask and trade messages per second, per
+	// instrument.
+	Rate float64
+
+	// TradeRatio is the share of messages that are trades. Defaults to 0.3.
+	TradeRatio float64
+
+	// MaxTicks is the largest random walk step. Defaults to 2.
+	MaxTicks int
+
+	// Sessions last SessionLength and are followed by a BreakLength break
+	// with no trading. A zero SessionLength keeps the session open.
+	SessionLength time.Duration
+	BreakLength   time.Duration
+
+	Seed int64
+}
+
+type instrument struct {
+	Instrument
+	tick       float64
+	last       int64 // prices in ticks, to keep them on the tick grid
+	bid        int64
+	ask        int64
+	bidSize    int64
+	askSize    int64
+	open       int64
+	high       int64
+	low        int64
+	previous   int64
+	lastSize   int64
+	volume     int64
+	numTrades  int64
+	tradeTime  time.Time
+	ticks      ddf.TickDirection
+	tradingDay time.Time
+}
+
+type Simulator struct {
+	config      Config
+	mu          sync.Mutex
+	rng         *rand.Rand
+	instruments []*instrument
+	bySymbol    map[string]*instrument
+	open        bool
+	sessionEnd  time.Time
+	lastUpdate  time.Time
+}
+
+func New(config Config) (*Simulator, error) {
+	if config.TradeRatio == 0 {
+		config.TradeRatio = 0.3
+	}
+
+	if config.MaxTicks == 0 {
+		config.MaxTicks = 2
+	}
+
+	s := &Simulator{
+		config:   config,
+		rng:      rand.New(rand.NewSource(config.Seed)),
+		bySymbol: make(map[string]*instrument),
+	}
+
+	for _, in := range config.Instruments {
+		tick := ddf.TickSize(in.BaseCode, in.TickIncrement)
+		if tick <= 0 {
+			return nil, fmt.Errorf("invalid base code %s or tick increment %d for %s", in.BaseCode, in.TickIncrement, in.Symbol)
+		}
+
+		last := int64(math.Round(in.Price / tick))
+		if last < 1 {
+			last = 1
+		}
+
+		i := &instrument{
+			Instrument: in,
+			tick:       tick,
+			last:       last,
+			previous:   last,
+		}
+		s.instruments = append(s.instruments, i)
+		s.bySymbol[in.Symbol] = i
+	}
+
+	return s, nil
+}
+
+func (s *Simulator) price(i *instrument, ticks int64) float64 {
+	return float64(ticks) * i.tick
+}
+
+func (s *Simulator) dayCode(i *instrument) byte {
+	day := i.tradingDay
+	if day.IsZero() {
+		day = time.Now()
+	}
+
+	code, _ := ddf.EncodeDayCode(day.In(ddf.Location()).Day())
+	return code
+}
+
+func (s *Simulator) info(i *instrument) ddf.DDFMessageInfo {
+	return ddf.DDFMessageInfo{
+		BaseCode: i.BaseCode,
+		Exchange: i.DDFExchange,
+		Delay:    0,
+		Record:   '2',
+		DayCode:  s.dayCode(i),
+		Session:  'G',
+	}
+}
+
+// wireTime keeps timestamps off millisecond values whose low byte is a line
+// feed, since jerq clients read one frame per line.
+func wireTime(t time.Time) time.Time {
+	t = t.Truncate(time.Millisecond)
+	if (t.Nanosecond()/int(time.Millisecond))&0xFF == '\n' {
+		t = t.Add(time.Millisecond)
+	}
+
+	return t
+}
+
+// Refresh returns the current state of symbol as a refresh message.
+func (s *Simulator) Refresh(symbol string) (ddf.MessageRefresh, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.bySymbol[symbol]
+	if i == nil {
+		return ddf.MessageRefresh{}, false
+	}
+
+	return s.refresh(i), true
+}
+
+func (s *Simulator) refresh(i *instrument) ddf.MessageRefresh {
+	m := ddf.MessageRefresh{}
+	m.Symbol = i.Symbol
+	m.Name = i.Name
+	m.Exchange = i.Exchange
+	m.DDFExchange = i.DDFExchange
+	m.BaseCode = i.BaseCode
+	m.PointValue = i.PointValue
+	m.TickIncrement = i.TickIncrement
+	m.Mode = ddf.ModeRealtime
+	m.LastUpdate = s.lastUpdate.Truncate(time.Second)
+
+	if s.open {
+		m.Bid = s.price(i, i.bid)
+		m.BidSize = i.bidSize
+		m.Ask = s.price(i, i.ask)
+		m.AskSize = i.askSize
+	}
+
+	m.CurrentSession = ddf.RefreshSession{
+		ID:         "combined",
+		Day:        string(s.dayCode(i)),
+		Session:    "G",
+		Timestamp:  m.LastUpdate,
+		Open:       s.price(i, i.open),
+		High:       s.price(i, i.high),
+		Low:        s.price(i, i.low),
+		Last:       s.price(i, i.last),
+		Previous:   s.price(i, i.previous),
+		TradeSize:  i.lastSize,
+		Volume:     i.volume,
+		NumTrades:  i.numTrades,
+		TradeTime:  i.tradeTime.Truncate(time.Second),
+		Ticks:      i.ticks,
+		Settlement: 0,
+	}
+	if !s.open {
+		m.CurrentSession.Settlement = s.price(i, i.last)
+	}
+
+	m.PreviousSession = ddf.RefreshSession{
+		ID:   "previous",
+		Last: s.price(i, i.previous),
+	}
+
+	return m
+}
+
+// openSession starts a new trading day, with the previous session's last
+// price as the settlement reference.
+func (s *Simulator) openSession(now time.Time, publish func(ddf.Message)) {
+	s.open = true
+	if s.config.SessionLength > 0 {
+		s.sessionEnd = now.Add(s.config.SessionLength)
+	}
+
+	for _, i := range s.instruments {
+		if !i.tradingDay.IsZero() {
+			i.tradingDay = i.tradingDay.AddDate(0, 0, 1)
+		} else {
+			i.tradingDay = now
+		}
+
+		i.previous = i.last
+		i.open, i.high, i.low = 0, 0, 0
+		i.volume, i.numTrades, i.lastSize = 0, 0, 0
+		i.bid, i.ask = i.last, i.last+1
+		i.bidSize = 1 + s.rng.Int63n(50)
+		i.askSize = 1 + s.rng.Int63n(50)
+
+		publish(s.refresh(i))
+	}
+}
+
+func (s *Simulator) closeSession(now time.Time, publish func(ddf.Message)) {
+	s.open = false
+	s.sessionEnd = now.Add(s.config.BreakLength)
+
+	for _, i := range s.instruments {
+		publish(s.refresh(i))
+	}
+}
+
+// step makes one random bid/ask or trade update.
+func (s *Simulator) step(now time.Time) ddf.Message {
+	i := s.instruments[s.rng.Intn(len(s.instruments))]
+
+	if s.rng.Float64() < s.config.TradeRatio {
+		price := i.bid
+		if s.rng.Intn(2) == 0 {
+			price = i.ask
+		}
+
+		switch {
+		case price > i.last:
+			i.ticks = ddf.TickUp
+		case price < i.last:
+			i.ticks = ddf.TickDown
+		}
+
+		size := 1 + s.rng.Int63n(20)
+		i.last = price
+		i.lastSize = size
+		i.volume += size
+		i.numTrades++
+		i.tradeTime = now
+		if i.open == 0 {
+			i.open = price
+		}
+		if price > i.high {
+			i.high = price
+		}
+		if i.low == 0 || price < i.low {
+			i.low = price
+		}
+
+		return ddf.MessageTrade{
+			Symbol:    i.Symbol,
+			Info:      s.info(i),
+			Trade:     s.price(i, price),
+			TradeSize: size,
+			Timestamp: now,
+		}
+	}
+
+	step := s.rng.Int63n(int64(2*s.config.MaxTicks+1)) - int64(s.config.MaxTicks)
+	i.bid += step
+	if i.bid < 1 {
+		i.bid = 1
+	}
+	i.ask = i.bid + 1 + s.rng.Int63n(2)
+	i.bidSize = 1 + s.rng.Int63n(50)
+	i.askSize = 1 + s.rng.Int63n(50)
+
+	return ddf.MessageBidAsk{
+		Symbol:    i.Symbol,
+		Info:      s.info(i),
+		Bid:       s.price(i, i.bid),
+		BidSize:   i.bidSize,
+		Ask:       s.price(i, i.ask),
+		AskSize:   i.askSize,
+		Timestamp: now,
+	}
+}
+
+// Run generates messages at the configured rate, passing each to publish,
+// until stop is closed. A timestamp message is published every second.
+func (s *Simulator) Run(stop <-chan struct{}, publish func(ddf.Message)) {
+	rate := s.config.Rate * float64(len(s.instruments))
+
+	ticker := time.NewTicker(time.Millisecond)
+	defer ticker.Stop()
+
+	s.mu.Lock()
+	s.lastUpdate = time.Now()
+	s.openSession(s.lastUpdate, publish)
+	s.mu.Unlock()
+
+	var (
+		last      = time.Now()
+		due       = 0.0
+		nextStamp = last.Truncate(time.Second).Add(time.Second)
+	)
+
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			s.mu.Lock()
+			s.lastUpdate = now
+
+			if !nextStamp.After(now) {
+				publish(ddf.MessageTimestamp{Timestamp: nextStamp})
+				nextStamp = nextStamp.Add(time.Second)
+			}
+
+			if !s.sessionEnd.IsZero() && !s.sessionEnd.After(now) {
+				if s.open {
+					s.closeSession(now, publish)
+				} else {
+					s.openSession(now, publish)
+				}
+			}
+
+			due += rate * now.Sub(last).Seconds()
+			last = now
+			if s.open {
+				ts := wireTime(now)
+				for ; due >= 1; due-- {
+					publish(s.step(ts))
+				}
+			}
+			due -= math.Floor(due)
+			s.mu.Unlock()
+		}
+	}
+}
+
+// Serve runs the simulator and serves its messages to jerq clients on addr
+// until stop is closed. Clients get a refresh for each symbol they subscribe
+// to.
+func (s *Simulator) Serve(addr string, stop <-chan struct{}) error {
+	server := ddf.NewServer()
+	server.OnSubscribe = func(c *ddf.ServerClient, symbols []string) {
+		for _, symbol := range symbols {
+			if m, ok := s.Refresh(symbol); ok {
+				c.Send(m)
+			}
+		}
+	}
+
+	go func() {
+		<-stop
+		server.Close()
+	}()
+
+	go s.Run(stop, func(m ddf.Message) {
+		err := server.Publish(m)
+		if err != nil {
+			log.Printf("Error publishing message. %v", err)
+		}
+	})
+
+	return server.ListenAndServe(addr)
+}
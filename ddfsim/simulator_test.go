@@ -0,0 +1,52 @@
+// Go ddfplus API Simulator
+//
+// Copyright 2019 Barchart.com, Inc. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the GNU license
+// available at https://github.com/barchart/go-ddfplus-api/blob/master/LICENSE.
+package ddfsim
+
+import (
+	ddf "barchart/go-ddfpus-api/src"
+	"math"
+	"testing"
+	"time"
+)
+
+func onTick(price float64, tick float64) bool {
+	n := price / tick
+	return math.Abs(n-math.Round(n)) < 1e-9
+}
+
+func TestSimulatorRespectsTicks(t *testing.T) {
+	sim, err := New(Config{
+		Instruments: []Instrument{
+			{Symbol: "ZCZ9", BaseCode: "2", TickIncrement: 2, Price: 387.3},
+			{Symbol: "ESZ9", BaseCode: "A", TickIncrement: 25, Price: 3000.1},
+		},
+		Seed: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var refreshes int
+	sim.openSession(time.Now(), func(m ddf.Message) { refreshes++ })
+	if refreshes != 2 {
+		t.Errorf("expected a refresh per symbol on open, got %d", refreshes)
+	}
+
+	ticks := map[string]float64{"ZCZ9": 0.25, "ESZ9": 0.25}
+	for i := 0; i < 1000; i++ {
+		switch m := sim.step(time.Now()).(type) {
+		case ddf.MessageTrade:
+			if !onTick(m.Trade, ticks[m.Symbol]) {
+				t.Fatalf("trade off the tick grid %+v", m)
+			}
+		case ddf.MessageBidAsk:
+			if !onTick(m.Bid, ticks[m.Symbol]) || !onTick(m.Ask, ticks[m.Symbol]) || m.Ask <= m.Bid {
+				t.Fatalf("invalid bid/ask %+v", m)
+			}
+		}
+	}
+}
@@ -0,0 +1,113 @@
+// Go ddfplus API Options
+//
+// Copyright 2019 Barchart.com, Inc. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the GNU license
+// available at https://github.com/barchart/go-ddfplus-api/blob/master/LICENSE.
+package options
+
+import (
+	"fmt"
+	"math"
+)
+
+// Greeks are the sensitivities of an option price. Vega is per volatility
+// point (0.01), and Theta per calendar day.
+type Greeks struct {
+	Delta float64 `json:"delta"`
+	Gamma float64 `json:"gamma"`
+	Vega  float64 `json:"vega"`
+	Theta float64 `json:"theta"`
+}
+
+const (
+	minVolatility = 1e-4
+	maxVolatility = 10.0
+)
+
+func normCDF(x float64) float64 {
+	return 0.5 * math.Erfc(-x/math.Sqrt2)
+}
+
+func normPDF(x float64) float64 {
+	return math.Exp(-x*x/2) / math.Sqrt(2*math.Pi)
+}
+
+func d1d2(f, k, t, vol float64) (float64, float64) {
+	sd := vol * math.Sqrt(t)
+	d1 := (math.Log(f/k) + sd*sd/2) / sd
+	return d1, d1 - sd
+}
+
+// Black76 returns the price of a call or put on a future at f with strike
+// k, t years to expiry, volatility vol and interest rate r.
+func Black76(call bool, f, k, t, vol, r float64) float64 {
+	df := math.Exp(-r * t)
+	if t <= 0 || vol <= 0 {
+		if call {
+			return df * math.Max(f-k, 0)
+		}
+		return df * math.Max(k-f, 0)
+	}
+
+	d1, d2 := d1d2(f, k, t, vol)
+	if call {
+		return df * (f*normCDF(d1) - k*normCDF(d2))
+	}
+
+	return df * (k*normCDF(-d2) - f*normCDF(-d1))
+}
+
+// Black76Greeks returns the Greeks of a call or put under Black76.
+func Black76Greeks(call bool, f, k, t, vol, r float64) Greeks {
+	if t <= 0 || vol <= 0 {
+		return Greeks{}
+	}
+
+	df := math.Exp(-r * t)
+	d1, _ := d1d2(f, k, t, vol)
+	sqrtT := math.Sqrt(t)
+	price := Black76(call, f, k, t, vol, r)
+
+	g := Greeks{
+		Gamma: df * normPDF(d1) / (f * vol * sqrtT),
+		Vega:  f * df * normPDF(d1) * sqrtT / 100,
+	}
+
+	theta := -f*df*normPDF(d1)*vol/(2*sqrtT) + r*price
+	g.Theta = theta / 365
+
+	if call {
+		g.Delta = df * normCDF(d1)
+	} else {
+		g.Delta = -df * normCDF(-d1)
+	}
+
+	return g
+}
+
+// ImpliedVolatility returns the Black76 volatility at which the option is
+// worth price. It fails when the price is outside the bounds of an option
+// price, such as below intrinsic value.
+func ImpliedVolatility(call bool, price, f, k, t, r float64) (float64, error) {
+	if f <= 0 || k <= 0 || t <= 0 {
+		return 0, fmt.Errorf("invalid future %v, strike %v or time %v", f, k, t)
+	}
+
+	lo, hi := minVolatility, maxVolatility
+	if price < Black76(call, f, k, t, lo, r) || price > Black76(call, f, k, t, hi, r) {
+		return 0, fmt.Errorf("price %v out of bounds", price)
+	}
+
+	// The price increases with volatility, so bisect
+	for i := 0; i < 100 && hi-lo > 1e-10; i++ {
+		mid := (lo + hi) / 2
+		if Black76(call, f, k, t, mid, r) < price {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+
+	return (lo + hi) / 2, nil
+}
@@ -0,0 +1,82 @@
+// Go ddfplus API Options
+//
+// Copyright 2019 Barchart.com, Inc. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the GNU license
+// available at https://github.com/barchart/go-ddfplus-api/blob/master/LICENSE.
+package options
+
+import (
+	ddf "barchart/go-ddfpus-api/src"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ExpiryRule returns the expiration of a contract month.
+type ExpiryRule func(year int, month time.Month) time.Time
+
+// ThirdFriday expires options on the third Friday of the month at 15:00
+// exchange time, as for CME equity index options.
+func ThirdFriday(year int, month time.Month) time.Time {
+	first := time.Date(year, month, 1, 15, 0, 0, 0, ddf.Location())
+	offset := (int(time.Friday) - int(first.Weekday()) + 7) % 7
+
+	return first.AddDate(0, 0, offset+14)
+}
+
+type calendarKey struct {
+	root  string
+	year  int
+	month time.Month
+}
+
+// Calendar holds option expirations. Dates set for a contract month win
+// over the rule of its root. Roots have no rule until one is set, as expiry
+// rules differ too much between products to have a default.
+type Calendar struct {
+	mu    sync.RWMutex
+	rules map[string]ExpiryRule
+	dates map[calendarKey]time.Time
+}
+
+func NewCalendar() *Calendar {
+	return &Calendar{
+		rules: make(map[string]ExpiryRule),
+		dates: make(map[calendarKey]time.Time),
+	}
+}
+
+// SetRule sets the expiry rule of the options of root.
+func (c *Calendar) SetRule(root string, rule ExpiryRule) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.rules[root] = rule
+}
+
+// SetExpiry sets the expiration of one contract month of root, such as one
+// moved by a holiday.
+func (c *Calendar) SetExpiry(root string, year int, month time.Month, expiry time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.dates[calendarKey{root, year, month}] = expiry
+}
+
+// Expiry returns the expiration of the options of root for a contract month,
+// or an error if root has neither a rule nor a date for the month.
+func (c *Calendar) Expiry(root string, year int, month time.Month) (time.Time, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if t, ok := c.dates[calendarKey{root, year, month}]; ok {
+		return t, nil
+	}
+
+	if rule := c.rules[root]; rule != nil {
+		return rule(year, month), nil
+	}
+
+	return time.Time{}, fmt.Errorf("no expiry rule for %s", root)
+}
@@ -0,0 +1,375 @@
+// Go ddfplus API Options
+//
+// Copyright 2019 Barchart.com, Inc. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the GNU license
+// available at https://github.com/barchart/go-ddfplus-api/blob/master/LICENSE.
+
+// Package options prices the futures options of a ddf.DB with Black-76:
+// implied volatility from the option's bid, ask, mid and last, and the
+// Greeks, against the underlying future in the same DB.
+package options
+
+import (
+	ddf "barchart/go-ddfpus-api/src"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// OptionQuote is an option quote with its analytics. An implied volatility
+// is zero when its price is missing or outside the bounds of an option
+// price. The Greeks are at Volatility, the mid volatility when there is a
+// bid and an ask, else the last one.
+type OptionQuote struct {
+	Symbol          string    `json:"symbol"`
+	Underlying      string    `json:"underlying"`
+	UnderlyingPrice float64   `json:"underlyingprice"`
+	Strike          float64   `json:"strike"`
+	Call            bool      `json:"call"`
+	Expiry          time.Time `json:"expiry"`
+	TimeToExpiry    float64   `json:"timetoexpiry"` // in years
+	Bid             float64   `json:"bid"`
+	Ask             float64   `json:"ask"`
+	Mid             float64   `json:"mid"`
+	Last            float64   `json:"last"`
+	BidIV           float64   `json:"bidiv"`
+	AskIV           float64   `json:"askiv"`
+	MidIV           float64   `json:"midiv"`
+	LastIV          float64   `json:"lastiv"`
+	Volatility      float64   `json:"volatility"`
+	Greeks
+	Quote *ddf.Quote `json:"quote"`
+}
+
+type Config struct {
+	Rate float64 // the annual interest rate, such as 0.02
+
+	// Calendar must have an expiry rule for each watched root. Options of
+	// other roots are not priced.
+	Calendar *Calendar
+
+	// StrikeDivisors convert the integer strikes of option symbols to
+	// prices, by root. Roots not listed use 1.
+	StrikeDivisors map[string]float64
+}
+
+// Analytics keeps the OptionQuote of every option of the roots it watches,
+// recomputing it when the option or its underlying future changes. Changes
+// are coalesced while a recompute runs, so a busy future costs one recompute
+// of its options at a time, at its latest price.
+type Analytics struct {
+	db     *ddf.DB
+	config Config
+	events chan ddf.ChainEvent
+	work   chan struct{} // signals pending changes to the worker
+	done   chan struct{}
+	once   sync.Once
+
+	mu        sync.Mutex
+	roots     []string
+	futures   map[string]bool // futures seen, by symbol
+	quotes    map[string]OptionQuote
+	options   map[string]map[string]bool // options by underlying
+	listeners []chan OptionQuote
+
+	// Changes not yet recomputed
+	pendingOptions map[string]bool
+	pendingFutures map[string]bool
+	pendingRoots   map[string]bool
+}
+
+func New(db *ddf.DB, config Config) *Analytics {
+	if config.Calendar == nil {
+		config.Calendar = NewCalendar()
+	}
+
+	a := &Analytics{
+		db:      db,
+		config:  config,
+		events:  make(chan ddf.ChainEvent, 64),
+		work:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		futures: make(map[string]bool),
+		quotes:  make(map[string]OptionQuote),
+		options: make(map[string]map[string]bool),
+
+		pendingOptions: make(map[string]bool),
+		pendingFutures: make(map[string]bool),
+		pendingRoots:   make(map[string]bool),
+	}
+
+	// Events are only recorded here, so that the DB is not held up by the
+	// recomputes of the worker
+	go func() {
+		for {
+			select {
+			case e := <-a.events:
+				a.handle(e)
+			case <-a.done:
+				return
+			}
+		}
+	}()
+
+	go func() {
+		for {
+			select {
+			case <-a.work:
+				for _, s := range a.takePending() {
+					a.update(s)
+				}
+			case <-a.done:
+				return
+			}
+		}
+	}()
+
+	return a
+}
+
+// handle records e for the worker to recompute.
+func (a *Analytics) handle(e ddf.ChainEvent) {
+	a.mu.Lock()
+	switch e.Type {
+	case ddf.FutureOption:
+		a.pendingOptions[e.Symbol] = true
+	case ddf.Future:
+		// A new future may be closer to some options than the one they
+		// are on, or be the first one they can be on
+		if a.futures[e.Symbol] {
+			a.pendingFutures[e.Symbol] = true
+		} else {
+			a.futures[e.Symbol] = true
+			a.pendingRoots[e.Root] = true
+		}
+	}
+	a.mu.Unlock()
+
+	select {
+	case a.work <- struct{}{}:
+	default:
+	}
+}
+
+// takePending returns the options to recompute for the recorded changes, and
+// clears them.
+func (a *Analytics) takePending() []string {
+	a.mu.Lock()
+	symbols := a.pendingOptions
+	for f := range a.pendingFutures {
+		for s := range a.options[f] {
+			symbols[s] = true
+		}
+	}
+	roots := a.pendingRoots
+
+	a.pendingOptions = make(map[string]bool)
+	a.pendingFutures = make(map[string]bool)
+	a.pendingRoots = make(map[string]bool)
+	a.mu.Unlock()
+
+	for root := range roots {
+		for _, s := range a.optionsOf(root) {
+			symbols[s] = true
+		}
+	}
+
+	list := make([]string, 0, len(symbols))
+	for s := range symbols {
+		list = append(list, s)
+	}
+
+	return list
+}
+
+// Watch computes the options of root, such as "ES", already in the DB, and
+// keeps them up to date.
+func (a *Analytics) Watch(root string) {
+	a.db.RegisterChain(root, a.events)
+	futures := a.db.Query(ddf.Query{Root: root, Type: ddf.Future})
+
+	a.mu.Lock()
+	a.roots = append(a.roots, root)
+	for _, q := range futures {
+		a.futures[q.Symbol] = true
+	}
+	a.mu.Unlock()
+
+	for _, s := range a.optionsOf(root) {
+		a.update(s)
+	}
+}
+
+// Stop stops watching the DB. It may be called more than once.
+func (a *Analytics) Stop() {
+	a.mu.Lock()
+	roots := a.roots
+	a.roots = nil
+	a.mu.Unlock()
+
+	// UnregisterChain returns once the DB is done sending to events, which
+	// needs the goroutine of New receiving them to keep running until then
+	for _, root := range roots {
+		a.db.UnregisterChain(root, a.events)
+	}
+
+	a.once.Do(func() {
+		close(a.done)
+	})
+}
+
+// Register sends ch every recomputed OptionQuote.
+func (a *Analytics) Register(ch chan OptionQuote) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, l := range a.listeners {
+		if l == ch {
+			return
+		}
+	}
+
+	a.listeners = append(a.listeners, ch)
+}
+
+// Get returns the last OptionQuote computed for symbol.
+func (a *Analytics) Get(symbol string) (OptionQuote, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	oq, ok := a.quotes[symbol]
+	return oq, ok
+}
+
+// optionsOf returns the options of root in the DB.
+func (a *Analytics) optionsOf(root string) []string {
+	var symbols []string
+	for _, q := range a.db.Query(ddf.Query{Root: root, Type: ddf.FutureOption}) {
+		symbols = append(symbols, q.Symbol)
+	}
+
+	return symbols
+}
+
+func (a *Analytics) update(symbol string) {
+	oq, err := a.Compute(symbol)
+	if err != nil {
+		return
+	}
+
+	a.mu.Lock()
+	if old, ok := a.quotes[symbol]; ok && old.Underlying != oq.Underlying {
+		delete(a.options[old.Underlying], symbol)
+	}
+	if a.options[oq.Underlying] == nil {
+		a.options[oq.Underlying] = make(map[string]bool)
+	}
+	a.options[oq.Underlying][symbol] = true
+	a.quotes[symbol] = oq
+	listeners := a.listeners
+	a.mu.Unlock()
+
+	for _, ch := range listeners {
+		ch <- oq
+	}
+}
+
+// underlyingPrice is the mid of the future when it has a bid and an ask,
+// else its last price.
+func underlyingPrice(q *ddf.Quote) float64 {
+	cur := q.Data.CurrentSession
+	if cur.Bid > 0 && cur.Ask > 0 {
+		return (cur.Bid + cur.Ask) / 2
+	}
+
+	return cur.Last
+}
+
+// Compute prices the option symbol from the DB as it is now.
+func (a *Analytics) Compute(symbol string) (OptionQuote, error) {
+	sym, err := ddf.ParseSymbol(symbol)
+	if err != nil || sym.Type != ddf.FutureOption {
+		return OptionQuote{}, fmt.Errorf("%s is not a futures option", symbol)
+	}
+
+	q := a.db.GetQuote(symbol)
+	if q == nil {
+		return OptionQuote{}, fmt.Errorf("no quote for %s", symbol)
+	}
+
+	underlying := a.db.Underlying(symbol)
+	uq := a.db.GetQuote(underlying)
+	if uq == nil {
+		return OptionQuote{}, fmt.Errorf("no underlying future for %s", symbol)
+	}
+
+	now := a.db.Timestamp()
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	year, month, ok := sym.ContractMonth(now)
+	if !ok {
+		return OptionQuote{}, fmt.Errorf("unknown month for %s", symbol)
+	}
+
+	divisor := a.config.StrikeDivisors[sym.Root]
+	if divisor == 0 {
+		divisor = 1
+	}
+
+	expiry, err := a.config.Calendar.Expiry(sym.Root, year, month)
+	if err != nil {
+		return OptionQuote{}, err
+	}
+
+	cur := q.Data.CurrentSession
+	oq := OptionQuote{
+		Symbol:          symbol,
+		Underlying:      underlying,
+		UnderlyingPrice: underlyingPrice(uq),
+		Strike:          float64(sym.Strike) / divisor,
+		Call:            sym.CallPut == "C",
+		Expiry:          expiry,
+		Bid:             cur.Bid,
+		Ask:             cur.Ask,
+		Last:            cur.Last,
+		Quote:           q,
+	}
+	oq.TimeToExpiry = oq.Expiry.Sub(now).Hours() / (24 * 365)
+
+	if oq.TimeToExpiry <= 0 {
+		return oq, fmt.Errorf("%s expired on %v", symbol, oq.Expiry)
+	}
+
+	iv := func(price float64) float64 {
+		if price <= 0 {
+			return 0
+		}
+
+		vol, err := ImpliedVolatility(oq.Call, price, oq.UnderlyingPrice, oq.Strike, oq.TimeToExpiry, a.config.Rate)
+		if err != nil {
+			return 0
+		}
+		return vol
+	}
+
+	oq.BidIV = iv(oq.Bid)
+	oq.AskIV = iv(oq.Ask)
+	oq.LastIV = iv(oq.Last)
+	if oq.Bid > 0 && oq.Ask > 0 {
+		oq.Mid = (oq.Bid + oq.Ask) / 2
+		oq.MidIV = iv(oq.Mid)
+	}
+
+	oq.Volatility = oq.MidIV
+	if oq.Volatility == 0 {
+		oq.Volatility = oq.LastIV
+	}
+	if oq.Volatility > 0 {
+		oq.Greeks = Black76Greeks(oq.Call, oq.UnderlyingPrice, oq.Strike, oq.TimeToExpiry, oq.Volatility, a.config.Rate)
+	}
+
+	return oq, nil
+}
@@ -0,0 +1,225 @@
+// Go ddfplus API Options
+//
+// Copyright 2019 Barchart.com, Inc. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the GNU license
+// available at https://github.com/barchart/go-ddfplus-api/blob/master/LICENSE.
+package options
+
+import (
+	ddf "barchart/go-ddfpus-api/src"
+	"math"
+	"testing"
+	"time"
+)
+
+func near(a, b, tolerance float64) bool {
+	return math.Abs(a-b) <= tolerance
+}
+
+func TestBlack76(t *testing.T) {
+	call := Black76(true, 100, 100, 1, 0.2, 0)
+	if !near(call, 7.965567, 1e-6) {
+		t.Errorf("unexpected call price %v", call)
+	}
+
+	// Put-call parity
+	c, p := Black76(true, 105, 100, 0.5, 0.3, 0.02), Black76(false, 105, 100, 0.5, 0.3, 0.02)
+	if !near(c-p, math.Exp(-0.01)*5, 1e-9) {
+		t.Errorf("parity does not hold, %v - %v", c, p)
+	}
+
+	vol, err := ImpliedVolatility(false, p, 105, 100, 0.5, 0.02)
+	if err != nil || !near(vol, 0.3, 1e-8) {
+		t.Errorf("unexpected implied volatility %v, %v", vol, err)
+	}
+
+	_, err = ImpliedVolatility(true, 4, 105, 100, 0.5, 0)
+	if err == nil {
+		t.Error("expected an error below intrinsic value")
+	}
+
+	// The Greeks match finite differences
+	g := Black76Greeks(true, 105, 100, 0.5, 0.3, 0.02)
+	price := func(f, tt, vol float64) float64 { return Black76(true, f, 100, tt, vol, 0.02) }
+	h := 1e-3
+	if d := (price(105+h, 0.5, 0.3) - price(105-h, 0.5, 0.3)) / (2 * h); !near(g.Delta, d, 1e-6) {
+		t.Errorf("delta %v, expected %v", g.Delta, d)
+	}
+	if d := (price(105+h, 0.5, 0.3) - 2*c + price(105-h, 0.5, 0.3)) / (h * h); !near(g.Gamma, d, 1e-4) {
+		t.Errorf("gamma %v, expected %v", g.Gamma, d)
+	}
+	if d := (price(105, 0.5, 0.3+h) - price(105, 0.5, 0.3-h)) / (2 * h) / 100; !near(g.Vega, d, 1e-6) {
+		t.Errorf("vega %v, expected %v", g.Vega, d)
+	}
+	if d := -(price(105, 0.5+h, 0.3) - price(105, 0.5-h, 0.3)) / (2 * h) / 365; !near(g.Theta, d, 1e-6) {
+		t.Errorf("theta %v, expected %v", g.Theta, d)
+	}
+}
+
+func TestCalendar(t *testing.T) {
+	c := NewCalendar()
+	if _, err := c.Expiry("ES", 2019, time.December); err == nil {
+		t.Error("expected an error for a root without a rule")
+	}
+
+	c.SetRule("ES", ThirdFriday)
+	if e, err := c.Expiry("ES", 2019, time.December); err != nil || e.Day() != 20 || e.Weekday() != time.Friday {
+		t.Errorf("unexpected expiry %v %v", e, err)
+	}
+
+	c.SetRule("CL", func(year int, month time.Month) time.Time {
+		return time.Date(year, month-1, 15, 13, 30, 0, 0, ddf.Location())
+	})
+	if e, _ := c.Expiry("CL", 2019, time.December); e.Month() != time.November || e.Day() != 15 {
+		t.Errorf("unexpected rule expiry %v", e)
+	}
+
+	holiday := time.Date(2020, 4, 9, 15, 0, 0, 0, ddf.Location())
+	c.SetExpiry("ES", 2020, time.April, holiday)
+	if e, _ := c.Expiry("ES", 2020, time.April); !e.Equal(holiday) {
+		t.Errorf("unexpected set expiry %v", e)
+	}
+}
+
+func TestAnalytics(t *testing.T) {
+	now := time.Date(2019, 11, 6, 15, 0, 0, 0, ddf.Location())
+	db := ddf.InitDB()
+	db.Process(ddf.MessageTimestamp{Timestamp: now})
+	db.Process(ddf.MessageRefresh{Symbol: "ESZ9", BaseCode: "A", Bid: 3000, Ask: 3000.5})
+
+	expiry := ThirdFriday(2019, time.December)
+	years := expiry.Sub(now).Hours() / (24 * 365)
+	price := Black76(true, 3000.25, 3000, years, 0.15, 0)
+	db.Process(ddf.MessageRefresh{Symbol: "ESZ3000C", BaseCode: "A", Bid: price - 0.25, Ask: price + 0.25})
+
+	calendar := NewCalendar()
+	calendar.SetRule("ES", ThirdFriday)
+	a := New(db, Config{Calendar: calendar})
+	defer a.Stop()
+	updates := make(chan OptionQuote, 10)
+	a.Register(updates)
+	a.Watch("ES")
+
+	oq := <-updates
+	if oq.Underlying != "ESZ9" || oq.UnderlyingPrice != 3000.25 || !oq.Expiry.Equal(expiry) {
+		t.Errorf("unexpected option quote %+v", oq)
+	}
+	if !near(oq.MidIV, 0.15, 1e-6) || oq.BidIV >= oq.MidIV || oq.AskIV <= oq.MidIV || oq.LastIV != 0 {
+		t.Errorf("unexpected volatilities %+v", oq)
+	}
+	if oq.Delta < 0.5 || oq.Delta > 0.6 || oq.Vega <= 0 || oq.Theta >= 0 {
+		t.Errorf("unexpected Greeks %+v", oq.Greeks)
+	}
+
+	// A move of the future reprices the option
+	db.Process(ddf.MessageBidAsk{Symbol: "ESZ9", Bid: 3010, Ask: 3010.5})
+	select {
+	case oq = <-updates:
+		if oq.UnderlyingPrice != 3010.25 || oq.MidIV >= 0.15 {
+			t.Errorf("unexpected repriced option %+v", oq)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no update for a move of the future")
+	}
+
+	if got, ok := a.Get("ESZ3000C"); !ok || got.UnderlyingPrice != 3010.25 {
+		t.Errorf("unexpected stored option quote %+v", got)
+	}
+}
+
+func TestAnalyticsNewFuture(t *testing.T) {
+	now := time.Date(2019, 11, 6, 15, 0, 0, 0, ddf.Location())
+	db := ddf.InitDB()
+	db.Process(ddf.MessageTimestamp{Timestamp: now})
+	db.Process(ddf.MessageRefresh{Symbol: "ESH0", BaseCode: "A", Bid: 3010, Ask: 3010.5})
+	db.Process(ddf.MessageRefresh{Symbol: "ESZ3000C", BaseCode: "A", Bid: 60, Ask: 61})
+	db.Process(ddf.MessageRefresh{Symbol: "CLZ5600C", BaseCode: "A", Bid: 1, Ask: 1.1})
+
+	calendar := NewCalendar()
+	calendar.SetRule("ES", ThirdFriday)
+	a := New(db, Config{Calendar: calendar})
+	updates := make(chan OptionQuote, 10)
+	a.Register(updates)
+	a.Watch("ES")
+	a.Watch("CL")
+
+	// Only ESH0 is in the DB, so the December option is on it for now
+	oq := <-updates
+	if oq.Symbol != "ESZ3000C" || oq.Underlying != "ESH0" {
+		t.Fatalf("unexpected option quote %+v", oq)
+	}
+
+	// CL has no expiry rule
+	if _, ok := a.Get("CLZ5600C"); ok {
+		t.Error("option priced without an expiry rule")
+	}
+
+	db.Process(ddf.MessageRefresh{Symbol: "ESZ9", BaseCode: "A", Bid: 3000, Ask: 3000.5})
+	select {
+	case oq = <-updates:
+		if oq.Underlying != "ESZ9" || oq.UnderlyingPrice != 3000.25 {
+			t.Errorf("unexpected option quote after a new future %+v", oq)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no update for a new future")
+	}
+
+	a.Stop()
+	a.Stop()
+
+	db.Process(ddf.MessageBidAsk{Symbol: "ESZ9", Bid: 3010, Ask: 3010.5})
+	select {
+	case oq = <-updates:
+		t.Errorf("update after Stop %+v", oq)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestAnalyticsCoalesce(t *testing.T) {
+	now := time.Date(2019, 11, 6, 15, 0, 0, 0, ddf.Location())
+	db := ddf.InitDB()
+	db.Process(ddf.MessageTimestamp{Timestamp: now})
+	db.Process(ddf.MessageRefresh{Symbol: "ESZ9", BaseCode: "A", Bid: 3000, Ask: 3000.5})
+	db.Process(ddf.MessageRefresh{Symbol: "ESZ3000C", BaseCode: "A", Bid: 60, Ask: 61})
+
+	calendar := NewCalendar()
+	calendar.SetRule("ES", ThirdFriday)
+	a := New(db, Config{Calendar: calendar})
+	defer a.Stop()
+	updates := make(chan OptionQuote, 1)
+	a.Register(updates)
+	a.Watch("ES")
+
+	// Nothing reads updates for now, so the worker blocks on its first
+	// recompute while the ticks pile up, which must not hold up the DB
+	processed := make(chan struct{})
+	go func() {
+		defer close(processed)
+		for i := 1; i <= 500; i++ {
+			db.Process(ddf.MessageBidAsk{Symbol: "ESZ9", Bid: 3000 + float64(i), Ask: 3000.5 + float64(i)})
+		}
+	}()
+
+	select {
+	case <-processed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("DB held up by the analytics")
+	}
+
+	n := 0
+	for {
+		select {
+		case oq := <-updates:
+			n++
+			if oq.UnderlyingPrice == 3500.25 {
+				if n > 4 {
+					t.Errorf("%d updates for 500 ticks", n)
+				}
+				return
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no update at the last price after %d updates", n)
+		}
+	}
+}
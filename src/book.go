@@ -0,0 +1,196 @@
+// Go ddfplus API
+//
+// Copyright 2019 Barchart.com, Inc. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the GNU license
+// available at https://github.com/barchart/go-ddfplus-api/blob/master/LICENSE.
+package ddf
+
+import "time"
+
+// Side is one side of an OrderBook.
+type Side int
+
+const (
+	SideBid Side = iota
+	SideAsk
+)
+
+// OrderBook is the depth of one symbol, best price first on each side. Each
+// book message replaces the whole book. Incremental depth updates are not
+// parsed, so between book messages the book is as of its Timestamp, behind
+// any later bid/ask. The feed has no order counts, only sizes.
+//
+// Inconsistent is set while the best bid or ask of the last book message
+// differed from the bid and ask of the quote when it arrived.
+type OrderBook struct {
+	Symbol       string      `json:"symbol"`
+	Bids         []BookLevel `json:"bids"`
+	Asks         []BookLevel `json:"asks"`
+	Timestamp    time.Time   `json:"timestamp"`
+	Inconsistent bool        `json:"inconsistent"`
+}
+
+func (b *OrderBook) levels(side Side) []BookLevel {
+	if side == SideBid {
+		return b.Bids
+	}
+
+	return b.Asks
+}
+
+// BestBid returns the first bid level, if any.
+func (b *OrderBook) BestBid() (BookLevel, bool) {
+	if len(b.Bids) == 0 {
+		return BookLevel{}, false
+	}
+
+	return b.Bids[0], true
+}
+
+// BestAsk returns the first ask level, if any.
+func (b *OrderBook) BestAsk() (BookLevel, bool) {
+	if len(b.Asks) == 0 {
+		return BookLevel{}, false
+	}
+
+	return b.Asks[0], true
+}
+
+// SizeAt returns the size at price on one side, or 0 if there is no level
+// at that price.
+func (b *OrderBook) SizeAt(side Side, price float64) int64 {
+	for _, l := range b.levels(side) {
+		if l.Price == price {
+			return l.Size
+		}
+	}
+
+	return 0
+}
+
+// CumulativeSize returns the total size of the best n levels on one side. An
+// n of 0 or less covers every level.
+func (b *OrderBook) CumulativeSize(side Side, n int) int64 {
+	levels := b.levels(side)
+	if n <= 0 || n > len(levels) {
+		n = len(levels)
+	}
+
+	var size int64
+	for _, l := range levels[:n] {
+		size += l.Size
+	}
+
+	return size
+}
+
+// Imbalance returns (bids - asks) / (bids + asks) over the sizes of the best n
+// levels, from -1 when there are only asks to 1 when there are only bids.
+func (b *OrderBook) Imbalance(n int) float64 {
+	bids := b.CumulativeSize(SideBid, n)
+	asks := b.CumulativeSize(SideAsk, n)
+	if bids+asks == 0 {
+		return 0
+	}
+
+	return float64(bids-asks) / float64(bids+asks)
+}
+
+// BookEvent reports that the top of an order book stopped, or again started,
+// matching the bid and ask of the quote when the book arrived.
+type BookEvent struct {
+	Symbol       string
+	Inconsistent bool
+	BookBid      float64
+	BookAsk      float64
+	Bid          float64
+	Ask          float64
+	Timestamp    time.Time
+}
+
+// setBook replaces the book of a symbol. The DB lock must be held.
+func (db *DB) setBook(m MessageBook) {
+	b := db.books[m.Symbol]
+	if b == nil {
+		b = &OrderBook{Symbol: m.Symbol}
+		db.books[m.Symbol] = b
+	}
+
+	// The message may be shared with other listeners, so keep copies
+	b.Bids = append(b.Bids[:0], m.Bids...)
+	b.Asks = append(b.Asks[:0], m.Asks...)
+	b.Timestamp = db.timestamp
+}
+
+// checkBook compares the book of symbol with the bid and ask of its quote,
+// returning an event when they start or stop agreeing. Only prices are
+// compared, and a side missing from either is not checked. It is only called
+// for book messages, as the quote is ahead of the book after a bid/ask. The
+// DB lock must be held.
+func (db *DB) checkBook(symbol string) *BookEvent {
+	b := db.books[symbol]
+	q := db.data[symbol]
+	if b == nil || q == nil {
+		return nil
+	}
+
+	e := BookEvent{
+		Symbol:    symbol,
+		Bid:       q.Data.CurrentSession.Bid,
+		Ask:       q.Data.CurrentSession.Ask,
+		Timestamp: db.timestamp,
+	}
+
+	inconsistent := false
+	if l, ok := b.BestBid(); ok {
+		e.BookBid = l.Price
+		inconsistent = e.Bid != 0 && l.Price != e.Bid
+	}
+	if l, ok := b.BestAsk(); ok {
+		e.BookAsk = l.Price
+		inconsistent = inconsistent || (e.Ask != 0 && l.Price != e.Ask)
+	}
+
+	if inconsistent == b.Inconsistent {
+		return nil
+	}
+
+	b.Inconsistent = inconsistent
+	e.Inconsistent = inconsistent
+	return &e
+}
+
+// Book returns a copy of the order book of symbol, or nil if there is none.
+func (db *DB) Book(symbol string) *OrderBook {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	b := db.books[symbol]
+	if b == nil {
+		return nil
+	}
+
+	c := *b
+	c.Bids = append([]BookLevel(nil), b.Bids...)
+	c.Asks = append([]BookLevel(nil), b.Asks...)
+	return &c
+}
+
+// RegisterBookEvents sends ch an event each time the book of a symbol stops,
+// or again starts, agreeing with its bid and ask.
+func (db *DB) RegisterBookEvents(ch chan BookEvent) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for _, l := range db.bookListeners {
+		if l == ch {
+			return
+		}
+	}
+
+	// Build a new slice, as Process may be ranging over the old one
+	updated := make([]chan BookEvent, 0, len(db.bookListeners)+1)
+	updated = append(updated, db.bookListeners...)
+	db.bookListeners = append(updated, ch)
+}
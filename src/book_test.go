@@ -0,0 +1,88 @@
+// Go ddfplus API
+//
+// Copyright 2019 Barchart.com, Inc. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the GNU license
+// available at https://github.com/barchart/go-ddfplus-api/blob/master/LICENSE.
+package ddf
+
+import "testing"
+
+func TestOrderBook(t *testing.T) {
+	db := InitDB()
+	events := make(chan BookEvent, 10)
+	db.RegisterBookEvents(events)
+
+	db.Process(MessageRefresh{Symbol: "ESZ9", BaseCode: "A", Bid: 3000.25, Ask: 3000.5})
+
+	m := MessageBook{
+		Symbol:   "ESZ9",
+		BaseCode: "A",
+		Bids:     []BookLevel{{3000.25, 30}, {3000, 50}, {2999.75, 20}},
+		Asks:     []BookLevel{{3000.5, 10}, {3000.75, 40}},
+	}
+	db.Process(m)
+
+	// The book keeps its own levels
+	m.Bids[0].Size = 1
+
+	b := db.Book("ESZ9")
+	if l, ok := b.BestBid(); !ok || l.Price != 3000.25 || l.Size != 30 {
+		t.Errorf("unexpected best bid %+v", l)
+	}
+	if l, ok := b.BestAsk(); !ok || l.Price != 3000.5 || l.Size != 10 {
+		t.Errorf("unexpected best ask %+v", l)
+	}
+
+	if b.SizeAt(SideBid, 3000) != 50 || b.SizeAt(SideAsk, 3000) != 0 {
+		t.Error("unexpected size at price")
+	}
+
+	if b.CumulativeSize(SideBid, 2) != 80 || b.CumulativeSize(SideBid, 0) != 100 || b.CumulativeSize(SideAsk, 5) != 50 {
+		t.Error("unexpected cumulative size")
+	}
+
+	if imb := b.Imbalance(1); imb != 0.5 {
+		t.Errorf("unexpected imbalance %v", imb)
+	}
+
+	if len(events) != 0 {
+		t.Fatalf("unexpected event %+v", <-events)
+	}
+
+	// A bid/ask is newer than the book, so is not a mismatch
+	db.Process(MessageBidAsk{Symbol: "ESZ9", Bid: 3000.5, BidSize: 5, Ask: 3000.75, AskSize: 40})
+	if len(events) != 0 {
+		t.Fatalf("unexpected event for a bid/ask %+v", <-events)
+	}
+	if db.Book("ESZ9").Inconsistent {
+		t.Error("book inconsistent after a bid/ask")
+	}
+
+	// A book that disagrees with the bid and ask when it arrives is
+	db.Process(MessageBook{
+		Symbol:   "ESZ9",
+		BaseCode: "A",
+		Bids:     []BookLevel{{3000.25, 6}},
+		Asks:     []BookLevel{{3000.75, 40}},
+	})
+	e := <-events
+	if !e.Inconsistent || e.BookBid != 3000.25 || e.Bid != 3000.5 || !db.Book("ESZ9").Inconsistent {
+		t.Errorf("unexpected event %+v", e)
+	}
+
+	db.Process(MessageBook{
+		Symbol:   "ESZ9",
+		BaseCode: "A",
+		Bids:     []BookLevel{{3000.5, 6}},
+		Asks:     []BookLevel{{3000.75, 40}},
+	})
+	e = <-events
+	if e.Inconsistent || db.Book("ESZ9").Inconsistent || db.Book("ESZ9").CumulativeSize(SideBid, 0) != 6 {
+		t.Errorf("unexpected event %+v", e)
+	}
+
+	if db.Book("CLZ9") != nil {
+		t.Error("expected no book for an unknown symbol")
+	}
+}
@@ -0,0 +1,279 @@
+// Go ddfplus API
+//
+// Copyright 2019 Barchart.com, Inc. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the GNU license
+// available at https://github.com/barchart/go-ddfplus-api/blob/master/LICENSE.
+package ddf
+
+import (
+	"sort"
+	"time"
+)
+
+// FuturesCurve is the futures of one root in the DB, nearest expiry first.
+type FuturesCurve struct {
+	Root      string
+	Contracts []*Quote
+}
+
+// OptionChain is the options on one future in the DB, by month, then by
+// strike.
+type OptionChain struct {
+	Underlying string
+	Months     []OptionMonth
+}
+
+// OptionMonth is the options of one month, nearest first, such as the serial
+// months before a quarterly future's own.
+type OptionMonth struct {
+	Month   string
+	Year    int
+	Strikes []OptionStrike // by increasing strike
+}
+
+// OptionStrike is the call and put at one strike. Either may be nil.
+type OptionStrike struct {
+	Strike int
+	Call   *Quote
+	Put    *Quote
+}
+
+// ChainEvent is sent to chain listeners when a quote of their root changes.
+type ChainEvent struct {
+	Root   string
+	Symbol string
+	Type   SymbolType
+	Quote  *Quote
+}
+
+// Get returns the option of month, strike and call/put ("C" or "P"), or nil.
+func (c *OptionChain) Get(month string, strike int, callPut string) *Quote {
+	for _, m := range c.Months {
+		if m.Month != month {
+			continue
+		}
+
+		for _, s := range m.Strikes {
+			if s.Strike == strike {
+				if callPut == "C" {
+					return s.Call
+				}
+				return s.Put
+			}
+		}
+	}
+
+	return nil
+}
+
+// contract is a symbol of the DB with its contract month.
+type contract struct {
+	symbol Symbol
+	quote  *Quote
+	year   int
+	month  time.Month
+}
+
+func (c contract) before(d contract) bool {
+	if c.year != d.year {
+		return c.year < d.year
+	}
+	if c.month != d.month {
+		return c.month < d.month
+	}
+
+	return c.quote.Symbol < d.quote.Symbol
+}
+
+// now is the feed time, or the wall clock before the first timestamp. The DB
+// lock must be held.
+func (db *DB) now() time.Time {
+	if db.timestamp.IsZero() {
+		return time.Now().In(Location())
+	}
+
+	return db.timestamp
+}
+
+// contracts returns copies of the quotes of root and type with a known
+// contract month, nearest first. The DB lock must be held.
+func (db *DB) contracts(root string, t SymbolType) []contract {
+	now := db.now()
+
+	var contracts []contract
+	for s, q := range db.data {
+		sym := db.symbols[s]
+		if sym.Root != root || sym.Type != t {
+			continue
+		}
+
+		year, month, ok := sym.ContractMonth(now)
+		if !ok {
+			continue
+		}
+
+		c := *q
+		contracts = append(contracts, contract{symbol: sym, quote: &c, year: year, month: month})
+	}
+
+	sort.Slice(contracts, func(i, j int) bool {
+		return contracts[i].before(contracts[j])
+	})
+
+	return contracts
+}
+
+// FuturesCurve returns the futures of root, such as "ES", ordered by expiry.
+func (db *DB) FuturesCurve(root string) FuturesCurve {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	curve := FuturesCurve{Root: root}
+	for _, c := range db.contracts(root, Future) {
+		curve.Contracts = append(curve.Contracts, c.quote)
+	}
+
+	return curve
+}
+
+// OptionChain returns the options on the future underlying, such as "ESZ9".
+// Option symbols carry no year, so an option belongs to the first future of
+// its root expiring in or after its month, which puts serial months on the
+// following quarterly future.
+func (db *DB) OptionChain(underlying string) OptionChain {
+	chain := OptionChain{Underlying: underlying}
+
+	sym, _ := ParseSymbol(underlying)
+	if sym.Type != Future {
+		return chain
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	year, month, ok := sym.ContractMonth(db.now())
+	if !ok {
+		return chain
+	}
+	target := contract{symbol: sym, quote: &Quote{Symbol: underlying}, year: year, month: month}
+
+	// The underlying may not be in the DB itself
+	futures := db.contracts(sym.Root, Future)
+	if _, found := db.data[underlying]; !found {
+		futures = append(futures, target)
+		sort.Slice(futures, func(i, j int) bool {
+			return futures[i].before(futures[j])
+		})
+	}
+
+	for _, o := range db.contracts(sym.Root, FutureOption) {
+		if underlyingOf(o, futures) != underlying {
+			continue
+		}
+
+		n := len(chain.Months)
+		if n == 0 || chain.Months[n-1].Month != o.symbol.Month || chain.Months[n-1].Year != o.year {
+			chain.Months = append(chain.Months, OptionMonth{Month: o.symbol.Month, Year: o.year})
+			n++
+		}
+		chain.Months[n-1].add(o)
+	}
+
+	for _, m := range chain.Months {
+		sort.Slice(m.Strikes, func(i, j int) bool {
+			return m.Strikes[i].Strike < m.Strikes[j].Strike
+		})
+	}
+
+	return chain
+}
+
+// underlyingOf returns the first of futures, which are ordered by expiry,
+// expiring in or after the month of option o.
+func underlyingOf(o contract, futures []contract) string {
+	for _, f := range futures {
+		if f.year > o.year || (f.year == o.year && f.month >= o.month) {
+			return f.quote.Symbol
+		}
+	}
+
+	return ""
+}
+
+// Underlying returns the future in the DB that the option symbol is on, as
+// in OptionChain, or "" if there is none.
+func (db *DB) Underlying(option string) string {
+	sym, _ := ParseSymbol(option)
+	if sym.Type != FutureOption {
+		return ""
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	year, month, ok := sym.ContractMonth(db.now())
+	if !ok {
+		return ""
+	}
+
+	return underlyingOf(contract{symbol: sym, year: year, month: month}, db.contracts(sym.Root, Future))
+}
+
+func (m *OptionMonth) add(o contract) {
+	i := 0
+	for i < len(m.Strikes) && m.Strikes[i].Strike != o.symbol.Strike {
+		i++
+	}
+	if i == len(m.Strikes) {
+		m.Strikes = append(m.Strikes, OptionStrike{Strike: o.symbol.Strike})
+	}
+
+	if o.symbol.CallPut == "C" {
+		m.Strikes[i].Call = o.quote
+	} else {
+		m.Strikes[i].Put = o.quote
+	}
+}
+
+// RegisterChain sends ch a ChainEvent for every change to a future or option
+// of root, including new contracts.
+func (db *DB) RegisterChain(root string, ch chan ChainEvent) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	listeners := db.chainListeners[root]
+	for _, l := range listeners {
+		if l == ch {
+			return
+		}
+	}
+
+	// Build a new slice, as Process may be ranging over the old one
+	updated := make([]chan ChainEvent, 0, len(listeners)+1)
+	updated = append(updated, listeners...)
+	db.chainListeners[root] = append(updated, ch)
+}
+
+// UnregisterChain stops sending events for root to ch. As with Unregister,
+// ch must be received from until it returns.
+func (db *DB) UnregisterChain(root string, ch chan ChainEvent) {
+	defer db.waitSends()
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	listeners := db.chainListeners[root]
+	remaining := make([]chan ChainEvent, 0, len(listeners))
+	for _, l := range listeners {
+		if l != ch {
+			remaining = append(remaining, l)
+		}
+	}
+
+	if len(remaining) > 0 {
+		db.chainListeners[root] = remaining
+	} else {
+		delete(db.chainListeners, root)
+	}
+}
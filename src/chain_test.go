@@ -0,0 +1,83 @@
+// Go ddfplus API
+//
+// Copyright 2019 Barchart.com, Inc. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the GNU license
+// available at https://github.com/barchart/go-ddfplus-api/blob/master/LICENSE.
+package ddf
+
+import (
+	"testing"
+	"time"
+)
+
+func TestContractMonth(t *testing.T) {
+	ref := time.Date(2019, 11, 6, 0, 0, 0, 0, time.UTC)
+	for _, c := range []struct {
+		symbol string
+		year   int
+		month  time.Month
+	}{
+		{"ESZ9", 2019, time.December},
+		{"ESH0", 2020, time.March},
+		{"ESZ8", 2028, time.December},
+		{"ESZ21", 2021, time.December},
+		{"ESZ3000C", 2019, time.December},
+		{"ESH3000P", 2020, time.March},
+	} {
+		sym, _ := ParseSymbol(c.symbol)
+		year, month, ok := sym.ContractMonth(ref)
+		if !ok || year != c.year || month != c.month {
+			t.Errorf("%s is %d %v, expected %d %v", c.symbol, year, month, c.year, c.month)
+		}
+	}
+}
+
+func TestChains(t *testing.T) {
+	db := InitDB()
+	db.Process(MessageTimestamp{Timestamp: time.Date(2019, 11, 6, 9, 30, 0, 0, time.UTC)})
+
+	events := make(chan ChainEvent, 20)
+	db.RegisterChain("ES", events)
+
+	for _, s := range []string{"ESM0", "ESZ9", "ESH0", "CLZ9", "ESZ3000C", "ESZ3000P", "ESZ2950C", "ESX3000C", "ESH3000C"} {
+		db.Process(MessageRefresh{Symbol: s, BaseCode: "A"})
+	}
+
+	curve := db.FuturesCurve("ES")
+	if s := symbolsOf(curve.Contracts); s != "ESZ9,ESH0,ESM0" {
+		t.Errorf("unexpected curve %s", s)
+	}
+
+	chain := db.OptionChain("ESZ9")
+	if len(chain.Months) != 2 || chain.Months[0].Month != "X" || chain.Months[1].Month != "Z" {
+		t.Fatalf("unexpected chain %+v", chain)
+	}
+
+	z := chain.Months[1]
+	if len(z.Strikes) != 2 || z.Strikes[0].Strike != 2950 || z.Strikes[0].Put != nil || z.Strikes[1].Put == nil {
+		t.Errorf("unexpected strikes %+v", z.Strikes)
+	}
+
+	if q := chain.Get("Z", 3000, "C"); q == nil || q.Symbol != "ESZ3000C" {
+		t.Errorf("unexpected option %+v", q)
+	}
+
+	if s := db.OptionChain("ESH0"); len(s.Months) != 1 || s.Months[0].Strikes[0].Call.Symbol != "ESH3000C" {
+		t.Errorf("unexpected chain %+v", s)
+	}
+
+	if u := db.Underlying("ESX3000C"); u != "ESZ9" {
+		t.Errorf("unexpected underlying %s", u)
+	}
+
+	if len(events) != 8 {
+		t.Errorf("expected 8 chain events, got %d", len(events))
+	}
+
+	db.UnregisterChain("ES", events)
+	db.Process(MessageRefresh{Symbol: "ESZ9", BaseCode: "A"})
+	if len(events) != 8 {
+		t.Error("unexpected event after unregister")
+	}
+}
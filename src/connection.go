@@ -8,25 +8,41 @@ package ddf
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 const (
-	JerqVersion = 4
+	JerqVersion   = 4
+	DefaultServer = "qs01.ddfplus.com:7500"
 )
 
 type Connection struct {
+	parseErrors             uint64 // first for 64-bit alignment of atomic access
+	mu                      sync.RWMutex
 	connected               bool
+	conn                    net.Conn
 	credentials             *Credentials
+	server                  string
 	settings                UserSettings
 	marketDepthChannels     map[string][]chan Message
 	marketUpdateChannels    map[string][]chan Message
 	marketUpdateAllChannels []chan Message
 	timestampChannels       []chan MessageTimestamp
+	rawChannels             []chan MessageRaw
+	parseErrorChannels      []chan ParseError
+	parser                  *Parser
+	recorder                *Recorder
+	breakerMaxErrors        int
+	breakerWindow           time.Duration
+	reconnectDelay          time.Duration
+	reconnect               bool
 }
 
 func (c *Connection) connect() {
@@ -43,7 +59,7 @@ func (c *Connection) buildRequest() string {
 
 	for s := range c.marketDepthChannels {
 		s2 := list[s]
-		s2 += "Ss"
+		s2 += "b"
 		list[s] = s2
 	}
 
@@ -64,11 +80,12 @@ func (c *Connection) Server() {
 }
 
 func (c *Connection) RegisterMarketUpdateAll(ch chan Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	add := true
-	for i, _ := range c.marketUpdateAllChannels {
+	for i := range c.marketUpdateAllChannels {
 		if c.marketUpdateAllChannels[i] == ch {
-			fmt.Println("DON'T ADD", ch)
-
 			add = false
 			break
 		}
@@ -79,7 +96,39 @@ func (c *Connection) RegisterMarketUpdateAll(ch chan Message) {
 	}
 }
 
+// subscribe sends a GO request for symbols if the session is running. The
+// initial request of a session comes from buildRequest instead.
+func (c *Connection) subscribe(symbols []string, flags string) {
+	if !c.connected || len(symbols) == 0 {
+		return
+	}
+
+	list := make([]string, len(symbols))
+	for i, s := range symbols {
+		list[i] = s + "=" + flags
+	}
+
+	_, err := fmt.Fprintf(c.conn, "GO %s\r\n", strings.Join(list, ","))
+	if err != nil {
+		log.Printf("Error subscribing to %v. %v", symbols, err)
+	}
+}
+
+func (c *Connection) unsubscribe(symbols []string) {
+	if !c.connected || len(symbols) == 0 {
+		return
+	}
+
+	_, err := fmt.Fprintf(c.conn, "STOP %s\r\n", strings.Join(symbols, ","))
+	if err != nil {
+		log.Printf("Error unsubscribing from %v. %v", symbols, err)
+	}
+}
+
 func (c *Connection) RegisterMarketUpdate(symbols []string, ch chan Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	newlist := make([]string, 0)
 
 	for _, s := range symbols {
@@ -90,7 +139,7 @@ func (c *Connection) RegisterMarketUpdate(symbols []string, ch chan Message) {
 		}
 
 		add := true
-		for i, _ := range channels {
+		for i := range channels {
 			if channels[i] == ch {
 				add = false
 				break
@@ -101,16 +150,119 @@ func (c *Connection) RegisterMarketUpdate(symbols []string, ch chan Message) {
 			channels = append(channels, ch)
 			c.marketUpdateChannels[s] = channels
 		}
+	}
+
+	c.subscribe(newlist, "Ss")
+}
+
+// UnregisterMarketUpdate removes ch from the listeners for symbols. Symbols
+// left with no listeners are unsubscribed from the server.
+func (c *Connection) UnregisterMarketUpdate(symbols []string, ch chan Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stoplist := make([]string, 0)
+
+	for _, s := range symbols {
+		channels := c.marketUpdateChannels[s]
+		if channels == nil {
+			continue
+		}
+
+		// Build a new slice, as dispatch may be ranging over the old one
+		remaining := make([]chan Message, 0, len(channels))
+		for i := range channels {
+			if channels[i] != ch {
+				remaining = append(remaining, channels[i])
+			}
+		}
+
+		if len(remaining) > 0 {
+			c.marketUpdateChannels[s] = remaining
+			continue
+		}
+
+		delete(c.marketUpdateChannels, s)
+		if c.marketDepthChannels[s] == nil {
+			stoplist = append(stoplist, s)
+		}
+	}
+
+	c.unsubscribe(stoplist)
+}
+
+func (c *Connection) RegisterMarketDepth(symbols []string, ch chan Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	newlist := make([]string, 0)
+
+	for _, s := range symbols {
+		channels := c.marketDepthChannels[s]
+		if channels == nil {
+			channels = make([]chan Message, 0)
+			newlist = append(newlist, s)
+		}
+
+		add := true
+		for i := range channels {
+			if channels[i] == ch {
+				add = false
+				break
+			}
+		}
+
+		if add {
+			channels = append(channels, ch)
+			c.marketDepthChannels[s] = channels
+		}
+	}
+
+	c.subscribe(newlist, "b")
+}
+
+// UnregisterMarketDepth removes ch from the depth listeners for symbols.
+// Symbols left with no listeners are unsubscribed from the server.
+func (c *Connection) UnregisterMarketDepth(symbols []string, ch chan Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stoplist := make([]string, 0)
+
+	for _, s := range symbols {
+		channels := c.marketDepthChannels[s]
+		if channels == nil {
+			continue
+		}
+
+		// Build a new slice, as dispatch may be ranging over the old one
+		remaining := make([]chan Message, 0, len(channels))
+		for i := range channels {
+			if channels[i] != ch {
+				remaining = append(remaining, channels[i])
+			}
+		}
 
-		if c.connected {
-			// TO DO: Maybe we need to reset the connection
+		if len(remaining) > 0 {
+			c.marketDepthChannels[s] = remaining
+			continue
+		}
+
+		delete(c.marketDepthChannels, s)
+		if c.marketUpdateChannels[s] == nil {
+			stoplist = append(stoplist, s)
 		}
 	}
+
+	c.unsubscribe(stoplist)
 }
 
 func (c *Connection) RegisterTimestamp(ch chan MessageTimestamp) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	add := true
-	for i, _ := range c.timestampChannels {
+	for i := range c.timestampChannels {
 		if c.timestampChannels[i] == ch {
 			add = false
 			break
@@ -122,103 +274,405 @@ func (c *Connection) RegisterTimestamp(ch chan MessageTimestamp) {
 	}
 }
 
+func (c *Connection) RegisterRaw(ch chan MessageRaw) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	add := true
+	for i := range c.rawChannels {
+		if c.rawChannels[i] == ch {
+			add = false
+			break
+		}
+	}
+
+	if add {
+		c.rawChannels = append(c.rawChannels, ch)
+	}
+}
+
+// ParseError is a message that could not be parsed, or whose handling
+// panicked. The session carries on after a parse error.
+type ParseError struct {
+	Data     []byte
+	Err      error
+	Panic    interface{}
+	Received time.Time
+}
+
+func (e ParseError) Error() string {
+	if e.Panic != nil {
+		return fmt.Sprintf("panic handling message %q. %v", e.Data, e.Panic)
+	}
+
+	return fmt.Sprintf("error parsing message %q. %v", e.Data, e.Err)
+}
+
+var errCircuitOpen = errors.New("too many parse errors")
+
+func (c *Connection) RegisterParseError(ch chan ParseError) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	add := true
+	for i := range c.parseErrorChannels {
+		if c.parseErrorChannels[i] == ch {
+			add = false
+			break
+		}
+	}
+
+	if add {
+		c.parseErrorChannels = append(c.parseErrorChannels, ch)
+	}
+}
+
+// SetCircuitBreaker makes the connection reconnect when more than maxErrors
+// parse errors happen within window. A maxErrors of 0 disables the breaker.
+func (c *Connection) SetCircuitBreaker(maxErrors int, window time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.breakerMaxErrors = maxErrors
+	c.breakerWindow = window
+}
+
+// ParseErrors returns the number of parse errors since the connection was
+// created.
+func (c *Connection) ParseErrors() uint64 {
+	return atomic.LoadUint64(&c.parseErrors)
+}
+
+// Record writes every frame received after login to r.
+func (c *Connection) Record(r *Recorder) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.recorder = r
+}
+
+// SetReconnect makes Start reconnect whenever the session ends, such as on a
+// network error, rather than return. Registrations are kept, so the new
+// session subscribes to the same symbols.
+func (c *Connection) SetReconnect(reconnect bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.reconnect = reconnect
+}
+
 func (c *Connection) Start() {
+	for {
+		err := c.session()
+
+		c.mu.RLock()
+		reconnect := c.reconnect
+		maxErrors, window := c.breakerMaxErrors, c.breakerWindow
+		c.mu.RUnlock()
+
+		switch {
+		case err == errCircuitOpen:
+			log.Printf("More than %d parse errors in %v, reconnecting.", maxErrors, window)
+		case !reconnect:
+			if err != nil {
+				log.Println(err)
+			}
+			return
+		default:
+			log.Println(err)
+			log.Println("Session ended, reconnecting.")
+		}
+
+		time.Sleep(c.reconnectDelay)
+	}
+}
+
+func (c *Connection) session() error {
 	// Dial the tcp
-	conn, err := net.Dial("tcp", "qs01.ddfplus.com:7500")
+	conn, err := net.Dial("tcp", c.server)
 	if err != nil {
-		log.Printf("Error connecting. %v", err)
-		return
+		return fmt.Errorf("Error connecting. %v", err)
 	}
+	defer conn.Close()
 
 	reader := bufio.NewReader(conn)
 
 	for {
-		line, _, err := reader.ReadLine()
-		if err == io.EOF {
-			break
+		line, err := readLine(reader)
+		if err != nil {
+			return fmt.Errorf("Network error. %v", err)
 		}
 
-		s := string(line)
-
-		if s[0] == '+' {
+		if len(line) > 0 && line[0] == '+' {
 			break
 		}
 	}
 
 	fmt.Fprintf(conn, "LOGIN %s:%s\r\n", c.credentials.Username, c.credentials.Password)
-	line, _, err := reader.ReadLine()
+	line, err := readLine(reader)
 	if err != nil {
-		log.Printf("Network error. %v", err)
-		return
+		return fmt.Errorf("Network error. %v", err)
 	}
 
-	if line[0] != '+' {
-		log.Printf("Error logging in. Server said: \"%s\"", string(line))
-		return
+	if len(line) == 0 || line[0] != '+' {
+		return fmt.Errorf("Error logging in. Server said: \"%s\"", string(line))
 	}
 
 	fmt.Fprintf(conn, "VERSION %d\r\n", JerqVersion)
-	line, _, err = reader.ReadLine()
+	line, err = readLine(reader)
 	if err != nil {
-		log.Printf("Network error. %v", err)
-		return
+		return fmt.Errorf("Network error. %v", err)
 	}
 
+	// Hold the lock until the session is marked connected, so that no
+	// registration in between is missed by both the GO request here and the
+	// live subscribe
+	c.mu.Lock()
 	command := c.buildRequest()
 
 	fmt.Printf("Sending \"%s\" to server.\n", command)
 	fmt.Fprintf(conn, "GO %s\r\n", command)
+	c.conn = conn
+	c.connected = true
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		c.conn = nil
+		c.connected = false
+		c.mu.Unlock()
+	}()
+
+	var (
+		windowStart = time.Now()
+		errorCount  = 0
+	)
+
 	for {
-		line, _, _ := reader.ReadLine()
-		m, err := Parse(line)
-		if err == nil {
-			if m != nil {
-				switch m.Type() {
-				case Timestamp:
-					var ts MessageTimestamp
-					ts = m.(MessageTimestamp)
-					for _, ch := range c.timestampChannels {
-						ch <- ts
-					}
-				case BidAsk, Refresh, Trade:
-					var symbol string
-					switch m.Type() {
-					case BidAsk:
-						symbol = m.(MessageBidAsk).Symbol
-					case Refresh:
-						symbol = m.(MessageRefresh).Symbol
-					case Trade:
-						symbol = m.(MessageTrade).Symbol
-					}
-
-					if symbol != "" {
-						for _, ch := range c.marketUpdateAllChannels {
-							ch <- m
-						}
-
-						for _, ch := range c.marketUpdateChannels[symbol] {
-							ch <- m
-						}
-					}
-				}
+		line, err := readLine(reader)
+		if err != nil {
+			return fmt.Errorf("Network error. %v", err)
+		}
+
+		c.mu.RLock()
+		recorder := c.recorder
+		maxErrors, window := c.breakerMaxErrors, c.breakerWindow
+		c.mu.RUnlock()
+
+		if recorder != nil {
+			err = recorder.Record(time.Now(), line)
+			if err != nil {
+				log.Printf("Error recording frame. %v", err)
+			}
+		}
+
+		perr := c.handle(line, nil)
+		if perr == nil {
+			continue
+		}
+
+		c.reportParseError(perr)
+
+		if maxErrors > 0 {
+			if time.Since(windowStart) > window {
+				windowStart = time.Now()
+				errorCount = 0
+			}
+
+			errorCount++
+			if errorCount > maxErrors {
+				return errCircuitOpen
 			}
-		} else {
-			log.Printf("Error parsing jerq data. %v", err)
 		}
 	}
+}
+
+// readLine reads a whole line from r. ReadLine returns lines longer than the
+// buffer, such as large XML refreshes, in fragments that would not parse.
+func readLine(r *bufio.Reader) ([]byte, error) {
+	line, isPrefix, err := r.ReadLine()
+	if err != nil || !isPrefix {
+		return line, err
+	}
 
-	conn.Close()
+	// The fragments are only valid until the next read
+	joined := append([]byte(nil), line...)
+	for isPrefix {
+		line, isPrefix, err = r.ReadLine()
+		if err != nil {
+			return nil, err
+		}
+		joined = append(joined, line...)
+	}
+
+	return joined, nil
 }
 
-func NewConnection(credentials *Credentials) (*Connection, error) {
+func (c *Connection) reportParseError(perr *ParseError) {
+	atomic.AddUint64(&c.parseErrors, 1)
+	log.Println(perr)
+
+	c.mu.RLock()
+	channels := c.parseErrorChannels
+	c.mu.RUnlock()
+
+	for _, ch := range channels {
+		ch <- *perr
+	}
+}
+
+// handle parses and dispatches a single message, recovering from any panic
+// so that one bad message cannot take the session down. Messages for which
+// accept returns false are not dispatched.
+func (c *Connection) handle(line []byte, accept func(Message) bool) (perr *ParseError) {
+	defer func() {
+		if r := recover(); r != nil {
+			perr = &ParseError{
+				Data:     append([]byte(nil), line...),
+				Panic:    r,
+				Received: time.Now(),
+			}
+		}
+	}()
+
+	m, err := c.parser.Parse(line)
+	if err != nil {
+		return &ParseError{
+			Data:     append([]byte(nil), line...),
+			Err:      err,
+			Received: time.Now(),
+		}
+	}
+
+	if m != nil && (accept == nil || accept(m)) {
+		c.dispatch(m)
+	}
+
+	return nil
+}
+
+func (c *Connection) dispatch(m Message) {
+	// Registrations replace slices rather than changing them in place, so
+	// the slices can be used after the lock is released. Channel sends must
+	// not hold the lock, as a listener may register from its receive loop.
+	c.mu.RLock()
+	all := c.marketUpdateAllChannels
+	c.mu.RUnlock()
+
+	switch m.Type() {
+	case Timestamp:
+		c.mu.RLock()
+		channels := c.timestampChannels
+		c.mu.RUnlock()
+
+		ts := m.(MessageTimestamp)
+		for _, ch := range channels {
+			ch <- ts
+		}
+	case BidAsk, Refresh, Trade, CumulativeVolume:
+		if symbol := MessageSymbol(m); symbol != "" {
+			c.mu.RLock()
+			channels := c.marketUpdateChannels[symbol]
+			c.mu.RUnlock()
+
+			for _, ch := range all {
+				ch <- m
+			}
+
+			for _, ch := range channels {
+				ch <- m
+			}
+		}
+	case Book:
+		c.mu.RLock()
+		channels := c.marketDepthChannels[m.(MessageBook).Symbol]
+		c.mu.RUnlock()
+
+		for _, ch := range all {
+			ch <- m
+		}
+
+		for _, ch := range channels {
+			ch <- m
+		}
+	case Raw:
+		c.mu.RLock()
+		channels := c.rawChannels
+		c.mu.RUnlock()
+
+		raw := m.(MessageRaw)
+		for _, ch := range channels {
+			ch <- raw
+		}
+	case XML:
+		for _, ch := range all {
+			ch <- m
+		}
+
+		if symbol := m.(MessageXML).Symbol; symbol != "" {
+			c.mu.RLock()
+			channels := c.marketUpdateChannels[symbol]
+			c.mu.RUnlock()
+
+			for _, ch := range channels {
+				ch <- m
+			}
+		}
+	}
+}
+
+// MessageSymbol returns the symbol a message is for, or "" if it has none.
+func MessageSymbol(m Message) string {
+	switch m := m.(type) {
+	case MessageBidAsk:
+		return m.Symbol
+	case MessageRefresh:
+		return m.Symbol
+	case MessageTrade:
+		return m.Symbol
+	case MessageCumulativeVolume:
+		return m.Symbol
+	case MessageBook:
+		return m.Symbol
+	case MessageXML:
+		return m.Symbol
+	case MessageRaw:
+		return m.Symbol
+	}
+
+	return ""
+}
+
+func newConnection(credentials *Credentials) *Connection {
 	conn := &Connection{
 		credentials: credentials,
+		server:      DefaultServer,
 	}
 
 	conn.marketDepthChannels = make(map[string][]chan Message)
 	conn.marketUpdateChannels = make(map[string][]chan Message)
 	conn.marketUpdateAllChannels = make([]chan Message, 0)
 	conn.timestampChannels = make([]chan MessageTimestamp, 0)
+	conn.rawChannels = make([]chan MessageRaw, 0)
+	conn.parseErrorChannels = make([]chan ParseError, 0)
+	conn.parser = NewParser()
+	conn.breakerMaxErrors = 100
+	conn.breakerWindow = 10 * time.Second
+	conn.reconnectDelay = 5 * time.Second
+
+	return conn
+}
+
+// NewConnectionTo creates a connection to the jerq server at addr, such as a
+// simulator or proxy, without looking up the user settings.
+func NewConnectionTo(addr string, credentials *Credentials) *Connection {
+	conn := newConnection(credentials)
+	conn.server = addr
+	return conn
+}
+
+func NewConnection(credentials *Credentials) (*Connection, error) {
+	conn := newConnection(credentials)
 
 	settings, err := GetUserSettings(credentials)
 	if err != nil {
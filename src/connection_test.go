@@ -0,0 +1,56 @@
+// Go ddfplus API
+//
+// Copyright 2019 Barchart.com, Inc. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the GNU license
+// available at https://github.com/barchart/go-ddfplus-api/blob/master/LICENSE.
+package ddf
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+func TestHandleRecoversFromPanic(t *testing.T) {
+	c := newConnection(nil)
+
+	// Sending on a closed channel panics
+	ch := make(chan Message)
+	close(ch)
+	c.RegisterMarketUpdateAll(ch)
+
+	perr := c.handle(benchTrade, nil)
+	if perr == nil || perr.Panic == nil {
+		t.Fatalf("expected a recovered panic, got %v", perr)
+	}
+
+	if string(perr.Data) != string(benchTrade) {
+		t.Errorf("expected the raw message, got %q", perr.Data)
+	}
+}
+
+func TestHandleParseError(t *testing.T) {
+	c := newConnection(nil)
+
+	perr := c.handle([]byte("\x012ESZ9,7\x02AM10306500,2"), nil)
+	if perr == nil || perr.Err == nil {
+		t.Fatalf("expected a parse error, got %v", perr)
+	}
+}
+
+func TestReadLine(t *testing.T) {
+	long := "%<QUOTE symbol=\"ESZ9\" name=\"" + strings.Repeat("E-Mini S&P 500 ", 10) + "\"/>"
+	r := bufio.NewReaderSize(strings.NewReader(long+"\r\nshort\n"), 16)
+
+	for _, want := range []string{long, "short"} {
+		line, err := readLine(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(line) != want {
+			t.Errorf("read %q, expected %q", line, want)
+		}
+	}
+}
@@ -9,40 +9,249 @@ package ddf
 import (
 	"fmt"
 	"log"
+	"math"
+	"sort"
+	"sync"
 	"time"
 )
 
 type Quote struct {
 	Symbol string `json:"symbol"`
 	Info   struct {
-		Name          string  `json:"name"`
-		Exchange      string  `json:"exchange"`
-		DDFExchange   string  `json:"ddfexchange"`
-		BaseCode      string  `json:"basecode"`
-		TickIncrement int     `json:"tickincrement"`
-		PointValue    float64 `json:"pointvalue"`
-	} `json:":info"`
+		Name          string    `json:"name"`
+		Exchange      string    `json:"exchange"`
+		DDFExchange   string    `json:"ddfexchange"`
+		BaseCode      string    `json:"basecode"`
+		TickIncrement int       `json:"tickincrement"`
+		PointValue    float64   `json:"pointvalue"`
+		Flag          string    `json:"flag"`
+		Mode          QuoteMode `json:"mode"`
+	} `json:"info"`
 	Data struct {
-		CurrentSession struct {
-			Bid       float64   `json:"bid"`
-			BidSize   int64     `json:"bidsize"`
-			Ask       float64   `json:"ask"`
-			AskSize   int64     `json:"asksize"`
-			Open      float64   `json:"open"`
-			High      float64   `json:"high"`
-			Low       float64   `json:"low"`
-			Last      float64   `json:"last"`
-			LastSize  int64     `json:"lastsize"`
-			TradeTime time.Time `json:"tradetime"`
-			Timestamp time.Time `json:timestamp"`
-		} `json:"current"`
+		CurrentSession  QuoteSession `json:"current"`
+		PreviousSession QuoteSession `json:"previous"`
 	} `json:"data"`
-	LastUpdate time.Time `json:"lastupdate"`
+	Derived    QuoteDerived `json:"derived"`
+	LastUpdate time.Time    `json:"lastupdate"`
+
+	// Stale is set on quotes loaded from a snapshot until a refresh from the
+	// feed confirms them.
+	Stale bool `json:"stale"`
+
+	// LastActivity is the time of the last message for the symbol, of any
+	// type, or the feed time for messages without one. Inactive is set once
+	// that is older than the stale threshold of the symbol, until the next
+	// message.
+	LastActivity time.Time `json:"lastactivity"`
+	Inactive     bool      `json:"inactive"`
+}
+
+// QuoteSession holds the state of one trading session. Previous is the
+// previous session's close, and PriceVolume the sum of price times size of
+// the session's trades over TradeVolume, which is less than Volume when the
+// refresh did not include the price volume.
+type QuoteSession struct {
+	Day          string    `json:"day"`
+	Bid          float64   `json:"bid"`
+	BidSize      int64     `json:"bidsize"`
+	Ask          float64   `json:"ask"`
+	AskSize      int64     `json:"asksize"`
+	Open         float64   `json:"open"`
+	High         float64   `json:"high"`
+	Low          float64   `json:"low"`
+	Last         float64   `json:"last"`
+	LastSize     int64     `json:"lastsize"`
+	Previous     float64   `json:"previous"`
+	Settlement   float64   `json:"settlement"`
+	Volume       int64     `json:"volume"`
+	OpenInterest int64     `json:"openinterest"`
+	NumTrades    int64     `json:"numtrades"`
+	PriceVolume  float64   `json:"pricevolume"`
+	TradeVolume  int64     `json:"tradevolume"`
+	TradeTime    time.Time `json:"tradetime"`
+	Timestamp    time.Time `json:"timestamp"`
+
+	// Traded is set once Open, High and Low hold prices. Zero is a valid
+	// price, such as for a spread, so it cannot mark them as unset.
+	Traded bool `json:"traded"`
+}
+
+// setSession copies the statistics of a refresh session.
+func (qs *QuoteSession) setSession(rs RefreshSession) {
+	qs.Day = rs.Day
+	qs.Open = rs.Open
+	qs.High = rs.High
+	qs.Low = rs.Low
+	qs.Last = rs.Last
+	qs.LastSize = rs.TradeSize
+	qs.Previous = rs.Previous
+	qs.Settlement = rs.Settlement
+	qs.Volume = rs.Volume
+	qs.OpenInterest = rs.OpenInterest
+	qs.NumTrades = rs.NumTrades
+	qs.PriceVolume = rs.PriceVolume
+	qs.TradeVolume = 0
+	if rs.PriceVolume != 0 {
+		qs.TradeVolume = rs.Volume
+	}
+	qs.TradeTime = rs.TradeTime
+	qs.Timestamp = rs.Timestamp
+
+	// A refresh sends no price for a session without trades, which parses
+	// as zero
+	qs.Traded = rs.Open != 0 || rs.High != 0 || rs.Low != 0
+}
+
+// refreshSession is the inverse of setSession.
+func (qs *QuoteSession) refreshSession(id string) RefreshSession {
+	return RefreshSession{
+		ID:           id,
+		Day:          qs.Day,
+		Timestamp:    qs.Timestamp,
+		Open:         qs.Open,
+		High:         qs.High,
+		Low:          qs.Low,
+		Last:         qs.Last,
+		Previous:     qs.Previous,
+		Settlement:   qs.Settlement,
+		TradeSize:    qs.LastSize,
+		Volume:       qs.Volume,
+		OpenInterest: qs.OpenInterest,
+		NumTrades:    qs.NumTrades,
+		PriceVolume:  qs.PriceVolume,
+		TradeTime:    qs.TradeTime,
+	}
+}
+
+// addTrade updates the session statistics with a trade.
+// equal reports whether qs and o hold the same values, comparing their times
+// with Equal.
+func (qs QuoteSession) equal(o QuoteSession) bool {
+	if !qs.TradeTime.Equal(o.TradeTime) || !qs.Timestamp.Equal(o.Timestamp) {
+		return false
+	}
+
+	qs.TradeTime, qs.Timestamp = time.Time{}, time.Time{}
+	o.TradeTime, o.Timestamp = time.Time{}, time.Time{}
+	return qs == o
+}
+
+func (qs *QuoteSession) addTrade(price float64, size int64, t time.Time) {
+	if !qs.Traded {
+		qs.Open = price
+		qs.High = price
+		qs.Low = price
+		qs.Traded = true
+	}
+	if price > qs.High {
+		qs.High = price
+	}
+	if price < qs.Low {
+		qs.Low = price
+	}
+
+	qs.Last = price
+	qs.LastSize = size
+	qs.Volume += size
+	qs.NumTrades++
+	qs.PriceVolume += price * float64(size)
+	qs.TradeVolume += size
+	qs.TradeTime = t
+	qs.Timestamp = t
+}
+
+// QuoteDerived holds values the DB derives from the rest of a quote. Changes
+// are against the previous settlement, or the previous close if there is no
+// settlement, and are zero without a last price or reference price.
+type QuoteDerived struct {
+	NetChange     float64 `json:"netchange"`
+	PercentChange float64 `json:"percentchange"`
+	VWAP          float64 `json:"vwap"`
+	Mid           float64 `json:"mid"`
+	Spread        float64 `json:"spread"`
+	SpreadTicks   int64   `json:"spreadticks"`
+	TradeCount    int64   `json:"tradecount"`
+}
+
+// Reference returns the price changes are measured against.
+func (q *Quote) Reference() float64 {
+	switch {
+	case q.Data.PreviousSession.Settlement != 0:
+		return q.Data.PreviousSession.Settlement
+	case q.Data.CurrentSession.Previous != 0:
+		return q.Data.CurrentSession.Previous
+	}
+
+	return q.Data.PreviousSession.Last
+}
+
+// derive updates the derived values.
+func (q *Quote) derive() {
+	cur := &q.Data.CurrentSession
+	d := QuoteDerived{TradeCount: cur.NumTrades}
+
+	if ref := q.Reference(); ref != 0 && cur.Last != 0 {
+		d.NetChange = cur.Last - ref
+		d.PercentChange = d.NetChange / ref * 100
+	}
+
+	if cur.TradeVolume > 0 {
+		d.VWAP = cur.PriceVolume / float64(cur.TradeVolume)
+	}
+
+	if cur.Bid != 0 && cur.Ask != 0 {
+		d.Mid = (cur.Bid + cur.Ask) / 2
+		d.Spread = cur.Ask - cur.Bid
+
+		if tick := TickSize(q.Info.BaseCode, q.Info.TickIncrement); tick > 0 {
+			d.SpreadTicks = int64(math.Round(d.Spread / tick))
+		}
+	}
+
+	q.Derived = d
+}
+
+// rollSession starts a new session on day, moving the current session to
+// the previous one.
+func (q *Quote) rollSession(day string) {
+	cur := q.Data.CurrentSession
+
+	previous := cur.Settlement
+	if previous == 0 {
+		previous = cur.Last
+	}
+
+	q.Data.PreviousSession = cur
+	q.Data.PreviousSession.Bid, q.Data.PreviousSession.BidSize = 0, 0
+	q.Data.PreviousSession.Ask, q.Data.PreviousSession.AskSize = 0, 0
+
+	q.Data.CurrentSession = QuoteSession{
+		Day:          day,
+		Bid:          cur.Bid,
+		BidSize:      cur.BidSize,
+		Ask:          cur.Ask,
+		AskSize:      cur.AskSize,
+		Previous:     previous,
+		OpenInterest: cur.OpenInterest,
+	}
 }
 
 type DB struct {
+	mu                  sync.RWMutex
+	sendMu              sync.Mutex // held by Process until its sends are done
 	data                map[string]*Quote
+	symbols             map[string]Symbol
 	listeners           map[string][]chan *Quote
+	deltaListeners      map[string][]deltaListener
+	history             map[string]*history
+	historyOptions      HistoryOptions
+	historyRecords      int
+	books               map[string]*OrderBook
+	bookListeners       []chan BookEvent
+	chainListeners      map[string][]chan ChainEvent
+	staleThresholds     StaleThresholds
+	staleListeners      []chan StaleEvent
+	timestampListeners  []chan MessageTimestamp
 	timestamp           time.Time
 	marketUpdateChannel chan Message
 }
@@ -52,18 +261,19 @@ func (db *DB) Connect(conn *Connection) {
 		return
 	}
 
+	// The connection waits for each send to be received before dispatching
+	// the next message, so a single receiver sees the feed in order
 	ch1 := make(chan MessageTimestamp)
-	go func() {
-		for m := range ch1 {
-			db.timestamp = m.Timestamp
-		}
-	}()
-	conn.RegisterTimestamp(ch1)
-
 	db.marketUpdateChannel = make(chan Message)
 
 	go func() {
-		for m := range db.marketUpdateChannel {
+		for {
+			var m Message
+			select {
+			case m = <-ch1:
+			case m = <-db.marketUpdateChannel:
+			}
+
 			err := db.Process(m)
 			if err != nil {
 				log.Printf("Error processing message. %v", err)
@@ -71,20 +281,209 @@ func (db *DB) Connect(conn *Connection) {
 			}
 		}
 	}()
+	conn.RegisterTimestamp(ch1)
 	conn.RegisterMarketUpdateAll(db.marketUpdateChannel)
 }
 
-func (db DB) GetQuote(symbol string) *Quote {
-	return db.data[symbol]
+// Refresh rebuilds a refresh message from the quote, such as for a client
+// that subscribes after the server's own refresh has gone by.
+func (q *Quote) Refresh() MessageRefresh {
+	m := MessageRefresh{}
+	m.Symbol = q.Symbol
+	m.Name = q.Info.Name
+	m.Exchange = q.Info.Exchange
+	m.DDFExchange = q.Info.DDFExchange
+	m.BaseCode = q.Info.BaseCode
+	m.Flag = q.Info.Flag
+	m.Mode = q.Info.Mode
+	m.TickIncrement = q.Info.TickIncrement
+	m.PointValue = q.Info.PointValue
+	m.LastUpdate = q.LastUpdate
+	m.Bid = q.Data.CurrentSession.Bid
+	m.BidSize = q.Data.CurrentSession.BidSize
+	m.Ask = q.Data.CurrentSession.Ask
+	m.AskSize = q.Data.CurrentSession.AskSize
+	m.CurrentSession = q.Data.CurrentSession.refreshSession("combined")
+	if q.Data.PreviousSession != (QuoteSession{}) {
+		m.PreviousSession = q.Data.PreviousSession.refreshSession("previous")
+	}
+
+	return m
+}
+
+// GetQuote returns a copy of the quote for symbol, or nil if there is none.
+func (db *DB) GetQuote(symbol string) *Quote {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	q := db.data[symbol]
+	if q == nil {
+		return nil
+	}
+
+	c := *q
+	return &c
+}
+
+// Symbols returns the symbols in the DB, sorted.
+func (db *DB) Symbols() []string {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	symbols := make([]string, 0, len(db.data))
+	for s := range db.data {
+		symbols = append(symbols, s)
+	}
+	sort.Strings(symbols)
+
+	return symbols
 }
 
+// Process applies m to the DB and sends a copy of the updated quote to the
+// listeners for its symbol, and the changes to the delta listeners whose
+// mask they match. Futures and options also go to the chain listeners of
+// their root. Book messages replace the order book of their symbol. Any
+// message for a symbol counts as activity, and timestamps check the other
+// symbols for inactivity, sending stale events to their listeners.
 func (db *DB) Process(m Message) error {
+	db.sendMu.Lock()
+	defer db.sendMu.Unlock()
+
+	db.mu.Lock()
+
+	var old *Quote
+	if q := db.data[MessageSymbol(m)]; q != nil {
+		c := *q
+		old = &c
+	}
+
+	q, err := db.apply(m)
+	if err != nil {
+		db.mu.Unlock()
+		return err
+	}
+
+	// A tick after the book is newer than it, so books are only checked as
+	// they arrive
+	var event *BookEvent
+	if m.Type() == Book {
+		event = db.checkBook(MessageSymbol(m))
+	}
+	bookListeners := db.bookListeners
+
+	var (
+		staleEvents []StaleEvent
+		inactive    []*Quote
+	)
+	if m.Type() == Timestamp {
+		staleEvents, inactive = db.checkActivity()
+	} else if e := db.touch(MessageSymbol(m), old, messageTime(m)); e != nil {
+		staleEvents = append(staleEvents, *e)
+	}
+
+	// Quotes going inactive change too, though the message is not theirs
+	var updates []quoteUpdate
+	if q != nil {
+		updates = append(updates, db.quoteUpdate(old, q))
+	}
+	for _, o := range inactive {
+		updates = append(updates, db.quoteUpdate(o, db.data[o.Symbol]))
+	}
+	staleListeners := db.staleListeners
+	timestampListeners := db.timestampListeners
+	db.mu.Unlock()
+
+	// Send without the lock, so listeners can read the DB as they receive
+	sendBookEvent(bookListeners, event)
+	sendStaleEvents(staleListeners, staleEvents)
+	for _, u := range updates {
+		u.send(m.Type())
+	}
+	if ts, ok := m.(MessageTimestamp); ok {
+		for _, ch := range timestampListeners {
+			ch <- ts
+		}
+	}
+
+	return nil
+}
+
+// quoteUpdate is a change to a quote, with the listeners to send it to.
+type quoteUpdate struct {
+	old            *Quote
+	new            *Quote
+	sym            Symbol
+	listeners      []chan *Quote
+	deltaListeners []deltaListener
+	chainListeners []chan ChainEvent
+}
+
+// quoteUpdate copies q, changed from old, and its listeners. The DB lock must
+// be held.
+func (db *DB) quoteUpdate(old *Quote, q *Quote) quoteUpdate {
+	c := *q
+	sym := db.symbols[q.Symbol]
+
+	return quoteUpdate{
+		old:            old,
+		new:            &c,
+		sym:            sym,
+		listeners:      db.listeners[q.Symbol],
+		deltaListeners: db.deltaListeners[q.Symbol],
+		chainListeners: db.chainListeners[sym.Root],
+	}
+}
+
+// send sends the update, made by a message of type t, to its listeners.
+func (u quoteUpdate) send(t MessageType) {
+	for _, ch := range u.listeners {
+		ch <- u.new
+	}
+
+	if len(u.chainListeners) > 0 {
+		e := ChainEvent{Root: u.sym.Root, Symbol: u.new.Symbol, Type: u.sym.Type, Quote: u.new}
+		for _, ch := range u.chainListeners {
+			ch <- e
+		}
+	}
+
+	if len(u.deltaListeners) > 0 {
+		delta := QuoteDelta{Symbol: u.new.Symbol, Type: t, Fields: diffQuotes(u.old, u.new), Old: u.old, New: u.new}
+		for _, l := range u.deltaListeners {
+			if delta.Fields&l.mask != 0 {
+				l.ch <- delta
+			}
+		}
+	}
+}
+
+// setQuote adds or replaces the quote of a symbol, parsing new symbols once
+// for queries. The DB lock must be held.
+func (db *DB) setQuote(q *Quote) {
+	if _, ok := db.data[q.Symbol]; !ok {
+		db.symbols[q.Symbol], _ = ParseSymbol(q.Symbol)
+	}
+	db.data[q.Symbol] = q
+}
+
+func sendBookEvent(listeners []chan BookEvent, e *BookEvent) {
+	if e == nil {
+		return
+	}
+
+	for _, ch := range listeners {
+		ch <- *e
+	}
+}
+
+// apply updates the DB with m, returning the quote it changed, if any.
+func (db *DB) apply(m Message) (*Quote, error) {
 	switch m.Type() {
 	case BidAsk:
 		ba := m.(MessageBidAsk)
 		q := db.data[ba.Symbol]
 		if q == nil {
-			return nil
+			return nil, nil
 		}
 
 		q.Data.CurrentSession.Bid = ba.Bid
@@ -92,6 +491,16 @@ func (db *DB) Process(m Message) error {
 		q.Data.CurrentSession.Ask = ba.Ask
 		q.Data.CurrentSession.AskSize = ba.AskSize
 		q.Data.CurrentSession.Timestamp = ba.Timestamp
+		q.derive()
+
+		db.record(ba.Symbol, nil, &BidAskRecord{
+			Time:    ba.Timestamp,
+			Bid:     ba.Bid,
+			BidSize: ba.BidSize,
+			Ask:     ba.Ask,
+			AskSize: ba.AskSize,
+		})
+		return q, nil
 
 	case Refresh:
 		rf := m.(MessageRefresh)
@@ -106,37 +515,57 @@ func (db *DB) Process(m Message) error {
 		q.Info.DDFExchange = rf.DDFExchange
 		q.Info.TickIncrement = rf.TickIncrement
 		q.Info.PointValue = rf.PointValue
-		q.Data.CurrentSession.Open = rf.CurrentSession.Open
-		q.Data.CurrentSession.High = rf.CurrentSession.High
-		q.Data.CurrentSession.Low = rf.CurrentSession.Low
-		q.Data.CurrentSession.Last = rf.CurrentSession.Last
+		q.Info.Flag = rf.Flag
+		q.Info.Mode = rf.Mode
+		q.Data.CurrentSession.setSession(rf.CurrentSession)
+		q.Data.PreviousSession.setSession(rf.PreviousSession)
 		q.Data.CurrentSession.Ask = rf.Ask
 		q.Data.CurrentSession.AskSize = rf.AskSize
 		q.Data.CurrentSession.Bid = rf.Bid
 		q.Data.CurrentSession.BidSize = rf.BidSize
 		q.LastUpdate = rf.LastUpdate
-		db.data[q.Symbol] = q
+		q.Stale = false
+		q.derive()
+		db.setQuote(q)
+		return q, nil
 
 	case Trade:
 		tr := m.(MessageTrade)
 		q := db.data[tr.Symbol]
 		if q == nil {
-			return nil
+			return nil, nil
 		}
 
-		q.Data.CurrentSession.Last = tr.Trade
-		q.Data.CurrentSession.LastSize = tr.TradeSize
-		q.Data.CurrentSession.TradeTime = tr.Timestamp
-		q.Data.CurrentSession.Timestamp = q.Data.CurrentSession.TradeTime
+		// A trade for another trading day starts a new session
+		if day := string(tr.Info.DayCode); tr.Info.DayCode != 0 && q.Data.CurrentSession.Day != "" && day != q.Data.CurrentSession.Day {
+			q.rollSession(day)
+		}
+
+		q.Data.CurrentSession.addTrade(tr.Trade, tr.TradeSize, tr.Timestamp)
+		q.derive()
+
+		db.record(tr.Symbol, &TradeRecord{Time: tr.Timestamp, Price: tr.Trade, Size: tr.TradeSize}, nil)
+		return q, nil
+
+	case Timestamp:
+		db.timestamp = m.(MessageTimestamp).Timestamp
+
+	case Book:
+		db.setBook(m.(MessageBook))
+
+	case CumulativeVolume, XML, Raw:
 
 	default:
-		return fmt.Errorf("unhandled type %v", m.Type())
+		return nil, fmt.Errorf("unhandled type %v", m.Type())
 	}
-	fmt.Println("DB Msg", m)
-	return nil
+
+	return nil, nil
 }
 
 func (db *DB) Register(symbols []string, ch chan *Quote) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
 	for _, s := range symbols {
 		channels := db.listeners[s]
 		if channels == nil {
@@ -158,14 +587,74 @@ func (db *DB) Register(symbols []string, ch chan *Quote) {
 	}
 }
 
+// Unregister stops sending quotes for symbols to ch. It returns once no send
+// to ch is in progress, so ch must be received from until then.
+func (db *DB) Unregister(symbols []string, ch chan *Quote) {
+	defer db.waitSends()
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for _, s := range symbols {
+		channels := db.listeners[s]
+
+		// Build a new slice, as Process may be ranging over the old one
+		remaining := make([]chan *Quote, 0, len(channels))
+		for i := range channels {
+			if channels[i] != ch {
+				remaining = append(remaining, channels[i])
+			}
+		}
+
+		if len(remaining) > 0 {
+			db.listeners[s] = remaining
+		} else {
+			delete(db.listeners, s)
+		}
+	}
+}
+
+// waitSends returns once the Process in progress, if any, is done sending.
+// The DB lock must not be held.
+func (db *DB) waitSends() {
+	db.sendMu.Lock()
+	db.sendMu.Unlock()
+}
+
 func (db *DB) Timestamp() time.Time {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
 	return db.timestamp
 }
 
+// RegisterTimestamp sends ch every timestamp message once the DB has
+// applied it. Listeners get quotes, deltas and timestamps in the order the
+// DB processed them.
+func (db *DB) RegisterTimestamp(ch chan MessageTimestamp) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for _, l := range db.timestampListeners {
+		if l == ch {
+			return
+		}
+	}
+
+	// Build a new slice, as Process may be ranging over the old one
+	updated := make([]chan MessageTimestamp, 0, len(db.timestampListeners)+1)
+	updated = append(updated, db.timestampListeners...)
+	db.timestampListeners = append(updated, ch)
+}
+
 func InitDB() *DB {
 	var db DB
 	db.data = make(map[string]*Quote)
+	db.symbols = make(map[string]Symbol)
 	db.listeners = make(map[string][]chan *Quote)
+	db.deltaListeners = make(map[string][]deltaListener)
+	db.books = make(map[string]*OrderBook)
+	db.chainListeners = make(map[string][]chan ChainEvent)
 
 	return &db
 }
@@ -0,0 +1,155 @@
+// Go ddfplus API
+//
+// Copyright 2019 Barchart.com, Inc. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the GNU license
+// available at https://github.com/barchart/go-ddfplus-api/blob/master/LICENSE.
+package ddf
+
+import (
+	"testing"
+	"time"
+)
+
+func testRefresh() MessageRefresh {
+	return MessageRefresh{
+		Symbol:   "ESZ9",
+		BaseCode: "A",
+		Bid:      3000.25,
+		Ask:      3000.5,
+		CurrentSession: RefreshSession{
+			ID:           "combined",
+			Day:          "E",
+			Open:         2995,
+			High:         3001,
+			Low:          2990,
+			Last:         3000.25,
+			Previous:     2994.5,
+			Volume:       1000,
+			OpenInterest: 250000,
+			NumTrades:    90,
+			PriceVolume:  2997000,
+		},
+		PreviousSession: RefreshSession{
+			ID:         "previous",
+			Day:        "D",
+			Last:       2994.5,
+			Settlement: 2994.75,
+			Volume:     150000,
+		},
+	}
+}
+
+func TestDBSessionStatistics(t *testing.T) {
+	db := InitDB()
+	db.Process(testRefresh())
+
+	q := db.GetQuote("ESZ9")
+	cur := q.Data.CurrentSession
+	if cur.Volume != 1000 || cur.OpenInterest != 250000 || cur.Previous != 2994.5 || cur.Day != "E" {
+		t.Errorf("unexpected current session %+v", cur)
+	}
+
+	if q.Data.PreviousSession.Settlement != 2994.75 || q.Data.PreviousSession.Volume != 150000 {
+		t.Errorf("unexpected previous session %+v", q.Data.PreviousSession)
+	}
+
+	info := DDFMessageInfo{BaseCode: "A", Exchange: "M", DayCode: 'E'}
+	db.Process(MessageTrade{Symbol: "ESZ9", Info: info, Trade: 3002, TradeSize: 5})
+	db.Process(MessageTrade{Symbol: "ESZ9", Info: info, Trade: 2989.5, TradeSize: 1})
+
+	cur = db.GetQuote("ESZ9").Data.CurrentSession
+	if cur.High != 3002 || cur.Low != 2989.5 || cur.Open != 2995 || cur.Last != 2989.5 {
+		t.Errorf("unexpected prices after trades %+v", cur)
+	}
+
+	if cur.Volume != 1006 || cur.NumTrades != 92 || cur.PriceVolume != 2997000+3002*5+2989.5 {
+		t.Errorf("unexpected statistics after trades %+v", cur)
+	}
+}
+
+func TestDBSessionRollover(t *testing.T) {
+	db := InitDB()
+	rf := testRefresh()
+	rf.CurrentSession.Settlement = 2999
+	db.Process(rf)
+
+	ts := time.Date(2019, 11, 6, 17, 0, 0, 0, time.UTC)
+	info := DDFMessageInfo{BaseCode: "A", Exchange: "M", DayCode: 'F'}
+	db.Process(MessageTrade{Symbol: "ESZ9", Info: info, Trade: 3001, TradeSize: 3, Timestamp: ts})
+
+	q := db.GetQuote("ESZ9")
+	cur, prev := q.Data.CurrentSession, q.Data.PreviousSession
+	if cur.Day != "F" || cur.Previous != 2999 || cur.Open != 3001 || cur.High != 3001 || cur.Low != 3001 {
+		t.Errorf("unexpected new session %+v", cur)
+	}
+
+	if cur.Volume != 3 || cur.NumTrades != 1 || cur.OpenInterest != 250000 || cur.Bid != 3000.25 {
+		t.Errorf("unexpected new session statistics %+v", cur)
+	}
+
+	if prev.Day != "E" || prev.Volume != 1000 || prev.Settlement != 2999 || prev.Bid != 0 {
+		t.Errorf("unexpected previous session %+v", prev)
+	}
+
+	rf2 := q.Refresh()
+	if rf2.PreviousSession.ID != "previous" || rf2.PreviousSession.Volume != 1000 || rf2.CurrentSession.Volume != 3 {
+		t.Errorf("unexpected refresh %+v", rf2)
+	}
+}
+
+func TestDBSessionZeroPrices(t *testing.T) {
+	db := InitDB()
+	db.Process(MessageRefresh{Symbol: "ESH0-ESZ9", BaseCode: "A", CurrentSession: RefreshSession{Day: "E"}})
+
+	// A calendar spread trades at, above and below zero
+	info := DDFMessageInfo{BaseCode: "A", Exchange: "M", DayCode: 'E'}
+	for _, price := range []float64{-0.5, 0, -1.25, 0.25} {
+		db.Process(MessageTrade{Symbol: "ESH0-ESZ9", Info: info, Trade: price, TradeSize: 1})
+	}
+
+	cur := db.GetQuote("ESH0-ESZ9").Data.CurrentSession
+	if !cur.Traded || cur.Open != -0.5 || cur.High != 0.25 || cur.Low != -1.25 || cur.Last != 0.25 {
+		t.Errorf("unexpected prices %+v", cur)
+	}
+
+	db.Process(MessageRefresh{Symbol: "NQZ9", BaseCode: "A", CurrentSession: RefreshSession{Day: "E"}})
+	db.Process(MessageTrade{Symbol: "NQZ9", Info: info, Trade: 0, TradeSize: 1})
+	db.Process(MessageTrade{Symbol: "NQZ9", Info: info, Trade: 8000, TradeSize: 1})
+
+	cur = db.GetQuote("NQZ9").Data.CurrentSession
+	if cur.Open != 0 || cur.High != 8000 || cur.Low != 0 {
+		t.Errorf("unexpected prices after a zero first trade %+v", cur)
+	}
+}
+
+func TestDBDerived(t *testing.T) {
+	db := InitDB()
+	rf := testRefresh()
+	rf.TickIncrement = 25
+	db.Process(rf)
+
+	d := db.GetQuote("ESZ9").Derived
+	if d.NetChange != 3000.25-2994.75 || d.Mid != 3000.375 || d.Spread != 0.25 || d.SpreadTicks != 1 {
+		t.Errorf("unexpected derived values %+v", d)
+	}
+
+	if d.VWAP != 2997 || d.TradeCount != 90 {
+		t.Errorf("unexpected VWAP or trade count %+v", d)
+	}
+
+	// Without the price volume, the VWAP covers only the trades seen since
+	rf.CurrentSession.PriceVolume = 0
+	db.Process(rf)
+	db.Process(MessageTrade{Symbol: "ESZ9", Trade: 3001, TradeSize: 1})
+	db.Process(MessageTrade{Symbol: "ESZ9", Trade: 3002, TradeSize: 3})
+
+	d = db.GetQuote("ESZ9").Derived
+	if d.VWAP != 3001.75 || d.TradeCount != 92 {
+		t.Errorf("unexpected VWAP or trade count %+v", d)
+	}
+
+	if pct := d.PercentChange; pct < 0.2420 || pct > 0.2421 {
+		t.Errorf("unexpected percent change %v", pct)
+	}
+}
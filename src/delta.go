@@ -0,0 +1,256 @@
+// Go ddfplus API
+//
+// Copyright 2019 Barchart.com, Inc. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the GNU license
+// available at https://github.com/barchart/go-ddfplus-api/blob/master/LICENSE.
+package ddf
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// QuoteField is a set of Quote fields, as a bitmask.
+type QuoteField uint64
+
+const (
+	FieldInfo QuoteField = 1 << iota // any of Quote.Info
+	FieldBid
+	FieldBidSize
+	FieldAsk
+	FieldAskSize
+	FieldOpen
+	FieldHigh
+	FieldLow
+	FieldLast
+	FieldLastSize
+	FieldTradeTime
+	FieldTimestamp
+	FieldLastUpdate
+	FieldPrevious
+	FieldSettlement
+	FieldVolume
+	FieldOpenInterest
+	FieldNumTrades
+	FieldPriceVolume
+	FieldDay
+	FieldPreviousSession // any of Quote.Data.PreviousSession
+	FieldDerived         // any of Quote.Derived
+	FieldStale
+	FieldInactive
+)
+
+const (
+	FieldBidAsk QuoteField = FieldBid | FieldBidSize | FieldAsk | FieldAskSize
+	FieldTrade  QuoteField = FieldLast | FieldLastSize | FieldTradeTime
+	FieldAll    QuoteField = ^QuoteField(0)
+)
+
+// quoteFields reads each field of a Quote, in bit order. Names match the
+// JSON tags.
+var quoteFields = []struct {
+	field QuoteField
+	name  string
+	get   func(q *Quote) interface{}
+}{
+	{FieldInfo, "info", func(q *Quote) interface{} { return q.Info }},
+	{FieldBid, "bid", func(q *Quote) interface{} { return q.Data.CurrentSession.Bid }},
+	{FieldBidSize, "bidsize", func(q *Quote) interface{} { return q.Data.CurrentSession.BidSize }},
+	{FieldAsk, "ask", func(q *Quote) interface{} { return q.Data.CurrentSession.Ask }},
+	{FieldAskSize, "asksize", func(q *Quote) interface{} { return q.Data.CurrentSession.AskSize }},
+	{FieldOpen, "open", func(q *Quote) interface{} { return q.Data.CurrentSession.Open }},
+	{FieldHigh, "high", func(q *Quote) interface{} { return q.Data.CurrentSession.High }},
+	{FieldLow, "low", func(q *Quote) interface{} { return q.Data.CurrentSession.Low }},
+	{FieldLast, "last", func(q *Quote) interface{} { return q.Data.CurrentSession.Last }},
+	{FieldLastSize, "lastsize", func(q *Quote) interface{} { return q.Data.CurrentSession.LastSize }},
+	{FieldTradeTime, "tradetime", func(q *Quote) interface{} { return q.Data.CurrentSession.TradeTime }},
+	{FieldTimestamp, "timestamp", func(q *Quote) interface{} { return q.Data.CurrentSession.Timestamp }},
+	{FieldLastUpdate, "lastupdate", func(q *Quote) interface{} { return q.LastUpdate }},
+	{FieldPrevious, "previous", func(q *Quote) interface{} { return q.Data.CurrentSession.Previous }},
+	{FieldSettlement, "settlement", func(q *Quote) interface{} { return q.Data.CurrentSession.Settlement }},
+	{FieldVolume, "volume", func(q *Quote) interface{} { return q.Data.CurrentSession.Volume }},
+	{FieldOpenInterest, "openinterest", func(q *Quote) interface{} { return q.Data.CurrentSession.OpenInterest }},
+	{FieldNumTrades, "numtrades", func(q *Quote) interface{} { return q.Data.CurrentSession.NumTrades }},
+	{FieldPriceVolume, "pricevolume", func(q *Quote) interface{} { return q.Data.CurrentSession.PriceVolume }},
+	{FieldDay, "day", func(q *Quote) interface{} { return q.Data.CurrentSession.Day }},
+	{FieldPreviousSession, "previoussession", func(q *Quote) interface{} { return q.Data.PreviousSession }},
+	{FieldDerived, "derived", func(q *Quote) interface{} { return q.Derived }},
+	{FieldStale, "stale", func(q *Quote) interface{} { return q.Stale }},
+	{FieldInactive, "inactive", func(q *Quote) interface{} { return q.Inactive }},
+}
+
+func (f QuoteField) Has(g QuoteField) bool {
+	return f&g != 0
+}
+
+// String lists the fields in f, such as "bid|ask".
+func (f QuoteField) String() string {
+	var names []string
+	for _, qf := range quoteFields {
+		if f&qf.field != 0 {
+			names = append(names, qf.name)
+		}
+	}
+
+	return strings.Join(names, "|")
+}
+
+// ParseQuoteFields reads a list of field names separated by ',' or '|', such
+// as "last,lastsize". The names "bidask", "trade" and "all" stand for the
+// matching groups.
+func ParseQuoteFields(s string) (QuoteField, error) {
+	var f QuoteField
+
+	for _, name := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == '|' }) {
+		name = strings.ToLower(strings.TrimSpace(name))
+
+		switch name {
+		case "bidask":
+			f |= FieldBidAsk
+			continue
+		case "trade":
+			f |= FieldTrade
+			continue
+		case "all":
+			f |= FieldAll
+			continue
+		}
+
+		found := false
+		for _, qf := range quoteFields {
+			if qf.name == name {
+				f |= qf.field
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return 0, fmt.Errorf("unknown quote field \"%s\"", name)
+		}
+	}
+
+	return f, nil
+}
+
+// QuoteDelta is the change one message made to a quote. Old is nil for a
+// quote's first refresh.
+type QuoteDelta struct {
+	Symbol string
+	Type   MessageType // of the message that made the change
+	Fields QuoteField
+	Old    *Quote
+	New    *Quote
+}
+
+// FieldChange is the old and new value of a single field.
+type FieldChange struct {
+	Field QuoteField
+	Name  string
+	Old   interface{}
+	New   interface{}
+}
+
+// Changes lists the changed fields with their values. Old values are nil
+// when there is no old quote.
+func (d QuoteDelta) Changes() []FieldChange {
+	var changes []FieldChange
+	for _, qf := range quoteFields {
+		if d.Fields&qf.field == 0 {
+			continue
+		}
+
+		c := FieldChange{Field: qf.field, Name: qf.name, New: qf.get(d.New)}
+		if d.Old != nil {
+			c.Old = qf.get(d.Old)
+		}
+		changes = append(changes, c)
+	}
+
+	return changes
+}
+
+// diffQuotes returns the fields that differ between old and new. Every field
+// set in new differs from a nil old.
+func diffQuotes(old *Quote, new *Quote) QuoteField {
+	if old == nil {
+		old = &Quote{}
+	}
+
+	var f QuoteField
+	for _, qf := range quoteFields {
+		if !equalField(qf.get(old), qf.get(new)) {
+			f |= qf.field
+		}
+	}
+
+	return f
+}
+
+// equalField compares two values of a quote field. Times are compared with
+// Equal, as == also compares their location and monotonic reading.
+func equalField(a, b interface{}) bool {
+	switch a := a.(type) {
+	case time.Time:
+		return a.Equal(b.(time.Time))
+	case QuoteSession:
+		return a.equal(b.(QuoteSession))
+	}
+
+	return a == b
+}
+
+type deltaListener struct {
+	mask QuoteField
+	ch   chan QuoteDelta
+}
+
+// RegisterDelta sends ch the QuoteDelta of every change to symbols that
+// touches a field in mask. RegisterDelta(symbols, FieldLast, ch) only hears
+// about changes of the last trade price.
+func (db *DB) RegisterDelta(symbols []string, mask QuoteField, ch chan QuoteDelta) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for _, s := range symbols {
+		listeners := db.deltaListeners[s]
+
+		// Build a new slice, as Process may be ranging over the old one
+		updated := make([]deltaListener, 0, len(listeners)+1)
+		for _, l := range listeners {
+			if l.ch != ch {
+				updated = append(updated, l)
+			}
+		}
+
+		db.deltaListeners[s] = append(updated, deltaListener{mask: mask, ch: ch})
+	}
+}
+
+// UnregisterDelta stops sending deltas for symbols to ch. As with
+// Unregister, ch must be received from until it returns.
+func (db *DB) UnregisterDelta(symbols []string, ch chan QuoteDelta) {
+	defer db.waitSends()
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for _, s := range symbols {
+		listeners := db.deltaListeners[s]
+
+		remaining := make([]deltaListener, 0, len(listeners))
+		for _, l := range listeners {
+			if l.ch != ch {
+				remaining = append(remaining, l)
+			}
+		}
+
+		if len(remaining) > 0 {
+			db.deltaListeners[s] = remaining
+		} else {
+			delete(db.deltaListeners, s)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+// Go ddfplus API
+//
+// Copyright 2019 Barchart.com, Inc. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the GNU license
+// available at https://github.com/barchart/go-ddfplus-api/blob/master/LICENSE.
+package ddf
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQuoteDelta(t *testing.T) {
+	db := InitDB()
+
+	all := make(chan QuoteDelta, 10)
+	trades := make(chan QuoteDelta, 10)
+	db.RegisterDelta([]string{"ESZ9"}, FieldAll, all)
+	db.RegisterDelta([]string{"ESZ9"}, FieldLast, trades)
+
+	db.Process(MessageRefresh{Symbol: "ESZ9", BaseCode: "A", Bid: 3000.25, Ask: 3000.5})
+	d := <-all
+	if d.Old != nil || d.Fields != FieldInfo|FieldBid|FieldAsk|FieldDerived {
+		t.Errorf("unexpected refresh delta %v %+v", d.Fields, d.Old)
+	}
+
+	ts := time.Date(2019, 11, 14, 9, 30, 0, 0, time.UTC)
+	db.Process(MessageBidAsk{Symbol: "ESZ9", Bid: 3000.25, BidSize: 5, Ask: 3000.75, AskSize: 0, Timestamp: ts})
+	d = <-all
+	if d.Fields != FieldBidSize|FieldAsk|FieldTimestamp|FieldDerived {
+		t.Errorf("unexpected bid/ask fields %v", d.Fields)
+	}
+
+	changes := d.Changes()
+	if len(changes) != 4 || changes[1].Name != "ask" || changes[1].Old != 3000.5 || changes[1].New != 3000.75 {
+		t.Errorf("unexpected changes %+v", changes)
+	}
+
+	db.Process(MessageTrade{Symbol: "ESZ9", Trade: 3000.5, TradeSize: 2, Timestamp: ts})
+	d = <-all
+	if d.Fields != FieldOpen|FieldHigh|FieldLow|FieldTrade|FieldVolume|FieldNumTrades|FieldPriceVolume|FieldDerived {
+		t.Errorf("unexpected trade fields %v", d.Fields)
+	}
+
+	select {
+	case d = <-trades:
+		if d.Old.Data.CurrentSession.Last != 0 || d.New.Data.CurrentSession.Last != 3000.5 {
+			t.Errorf("unexpected trade delta %+v", d)
+		}
+	default:
+		t.Fatal("no trade delta")
+	}
+
+	if len(trades) != 0 {
+		t.Errorf("filtered listener got %d extra deltas", len(trades))
+	}
+
+	db.UnregisterDelta([]string{"ESZ9"}, all)
+	db.Process(MessageTrade{Symbol: "ESZ9", Trade: 3000.75, TradeSize: 1, Timestamp: ts})
+	if len(all) != 0 || len(trades) != 1 {
+		t.Errorf("unexpected deltas after unregister, %d and %d", len(all), len(trades))
+	}
+}
+
+func TestQuoteDeltaTimeLocation(t *testing.T) {
+	ts := time.Date(2019, 11, 14, 15, 30, 0, 0, time.UTC)
+	now := time.Now()
+
+	old := &Quote{Symbol: "ESZ9", LastUpdate: ts}
+	old.Data.CurrentSession.TradeTime = now
+	old.Data.PreviousSession.Timestamp = ts
+
+	// The same instants in another location, and without a monotonic reading
+	c := *old
+	c.LastUpdate = ts.In(Location())
+	c.Data.CurrentSession.TradeTime = now.Round(0)
+	c.Data.PreviousSession.Timestamp = ts.In(Location())
+
+	if f := diffQuotes(old, &c); f != 0 {
+		t.Errorf("unexpected fields %v for equal times", f)
+	}
+
+	c.Data.PreviousSession.Timestamp = ts.Add(time.Second)
+	if f := diffQuotes(old, &c); f != FieldPreviousSession {
+		t.Errorf("unexpected fields %v", f)
+	}
+}
+
+func TestParseQuoteFields(t *testing.T) {
+	f, err := ParseQuoteFields("last, bidask|timestamp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if f != FieldLast|FieldBidAsk|FieldTimestamp {
+		t.Errorf("unexpected fields %v", f)
+	}
+
+	if f.String() != "bid|bidsize|ask|asksize|last|timestamp" {
+		t.Errorf("unexpected string %s", f)
+	}
+
+	_, err = ParseQuoteFields("last,volume2")
+	if err == nil {
+		t.Error("expected an error for an unknown field")
+	}
+}
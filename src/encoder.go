@@ -0,0 +1,447 @@
+// Go ddfplus API
+//
+// Copyright 2019 Barchart.com, Inc. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the GNU license
+// available at https://github.com/barchart/go-ddfplus-api/blob/master/LICENSE.
+package ddf
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Scaled prices must stay well inside float64 precision to survive a round
+// trip through ParseFloat unchanged.
+const maxExactPrice = 1 << 50
+
+// AppendFloat appends price f formatted in base code bc, the inverse of
+// ParseFloatBytes.
+func AppendFloat(dst []byte, f float64, bc byte) ([]byte, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return dst, fmt.Errorf("invalid price %v", f)
+	}
+
+	if f < 0 {
+		dst = append(dst, '-')
+		f = -f
+	}
+
+	var (
+		digits int
+		denom  float64
+	)
+
+	switch bc {
+	case '2':
+		digits, denom = 1, 8
+	case '3':
+		digits, denom = 2, 16
+	case '4':
+		digits, denom = 2, 32
+	case '5':
+		digits, denom = 2, 64
+	case '6':
+		digits, denom = 3, 128
+	case '7':
+		digits, denom = 3, 256
+	case '8', '9', 'A', 'B', 'C', 'D', 'E', 'F':
+		i := int(bc - '8')
+		if bc >= 'A' {
+			i = int(bc-'A') + 2
+		}
+
+		n := math.Round(f * decimalDivisors[i])
+		if n >= maxExactPrice {
+			return dst, fmt.Errorf("price %v out of range", f)
+		}
+
+		return strconv.AppendInt(dst, int64(n), 10), nil
+	default:
+		return dst, fmt.Errorf("unsupported base code %d", bc)
+	}
+
+	n := math.Floor(f)
+	d := math.Round((f - n) * denom)
+	if d >= denom {
+		n++
+		d -= denom
+	}
+
+	if n >= maxExactPrice {
+		return dst, fmt.Errorf("price %v out of range", f)
+	}
+
+	dst = strconv.AppendInt(dst, int64(n), 10)
+
+	num := strconv.Itoa(int(d))
+	for i := len(num); i < digits; i++ {
+		dst = append(dst, '0')
+	}
+
+	return append(dst, num...), nil
+}
+
+// FormatFloat formats price f in base code bc.
+func FormatFloat(f float64, bc string) (string, error) {
+	if len(bc) == 0 {
+		return "", fmt.Errorf("missing base code")
+	}
+
+	b, err := AppendFloat(nil, f, bc[0])
+	return string(b), err
+}
+
+// AppendTimestamp appends the <ETX> and binary timestamp that end a type 2
+// message, the inverse of ParseTimestamp.
+func AppendTimestamp(dst []byte, t time.Time) ([]byte, error) {
+	dst = append(dst, 3)
+	if t.IsZero() {
+		return dst, nil
+	}
+
+	t = t.In(Location())
+	year := t.Year()
+	if year < 0 || year/100 > 255 {
+		return dst, fmt.Errorf("year %d out of range", year)
+	}
+
+	ms := t.Nanosecond() / int(time.Millisecond)
+
+	return append(dst,
+		byte(year/100),
+		byte(64+year%100),
+		byte(64+int(t.Month())),
+		byte(64+t.Day()),
+		byte(64+t.Hour()),
+		byte(64+t.Minute()),
+		byte(64+t.Second()),
+		byte(ms&0xFF),
+		byte(ms>>8),
+	), nil
+}
+
+func appendHeader(dst []byte, symbol string, info DDFMessageInfo, subrecord byte) ([]byte, error) {
+	if len(info.BaseCode) != 1 || len(info.Exchange) != 1 {
+		return dst, fmt.Errorf("invalid base code or exchange for %s", symbol)
+	}
+
+	if strings.IndexByte(symbol, ',') != -1 {
+		return dst, fmt.Errorf("invalid symbol %s", symbol)
+	}
+
+	if info.Delay < -9 || info.Delay > 99 {
+		return dst, fmt.Errorf("delay %d out of range for %s", info.Delay, symbol)
+	}
+
+	dst = append(dst, 1, '2')
+	dst = append(dst, symbol...)
+	dst = append(dst, ',', subrecord, 2, info.BaseCode[0], info.Exchange[0])
+	if info.Delay >= 0 && info.Delay < 10 {
+		dst = append(dst, '0')
+	}
+
+	return strconv.AppendInt(dst, int64(info.Delay), 10), nil
+}
+
+func appendTrailer(dst []byte, info DDFMessageInfo, t time.Time) ([]byte, error) {
+	dst = append(dst, info.DayCode, info.Session)
+	return AppendTimestamp(dst, t)
+}
+
+func appendAttr(dst []byte, name string, value string) []byte {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(value))
+
+	dst = append(dst, ' ')
+	dst = append(dst, name...)
+	dst = append(dst, '=', '"')
+	dst = append(dst, buf.Bytes()...)
+	return append(dst, '"')
+}
+
+// appendPriceAttr writes a price attribute, leaving zero prices empty the way
+// the server sends missing values.
+func appendPriceAttr(dst []byte, name string, f float64, bc byte) ([]byte, error) {
+	if f == 0 {
+		return appendAttr(dst, name, ""), nil
+	}
+
+	price, err := AppendFloat(nil, f, bc)
+	if err != nil {
+		return dst, err
+	}
+
+	return appendAttr(dst, name, string(price)), nil
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.In(Location()).Format("20060102150405")
+}
+
+func appendSession(dst []byte, session RefreshSession, bc byte) ([]byte, error) {
+	var err error
+
+	dst = append(dst, "<SESSION"...)
+	dst = appendAttr(dst, "day", session.Day)
+	dst = appendAttr(dst, "session", session.Session)
+	dst = appendAttr(dst, "timestamp", formatTime(session.Timestamp))
+
+	prices := []struct {
+		name  string
+		price float64
+	}{
+		{"open", session.Open},
+		{"high", session.High},
+		{"low", session.Low},
+		{"last", session.Last},
+		{"previous", session.Previous},
+		{"settlement", session.Settlement},
+	}
+	for _, p := range prices {
+		dst, err = appendPriceAttr(dst, p.name, p.price, bc)
+		if err != nil {
+			return dst, err
+		}
+	}
+
+	dst = appendAttr(dst, "tradesize", strconv.FormatInt(session.TradeSize, 10))
+	dst = appendAttr(dst, "volume", strconv.FormatInt(session.Volume, 10))
+	dst = appendAttr(dst, "openinterest", strconv.FormatInt(session.OpenInterest, 10))
+	dst = appendAttr(dst, "numtrades", strconv.FormatInt(session.NumTrades, 10))
+	dst = appendAttr(dst, "pricevolume", strconv.FormatFloat(session.PriceVolume, 'f', -1, 64))
+	dst = appendAttr(dst, "tradetime", formatTime(session.TradeTime))
+	dst = appendAttr(dst, "ticks", session.Ticks.Code())
+	dst = appendAttr(dst, "id", session.ID)
+
+	return append(dst, "/>"...), nil
+}
+
+func appendRefresh(dst []byte, m MessageRefresh) ([]byte, error) {
+	if len(m.BaseCode) != 1 {
+		return dst, fmt.Errorf("invalid base code for %s", m.Symbol)
+	}
+	bc := m.BaseCode[0]
+
+	var err error
+
+	dst = append(dst, "%<QUOTE"...)
+	dst = appendAttr(dst, "symbol", m.Symbol)
+	dst = appendAttr(dst, "name", m.Name)
+	dst = appendAttr(dst, "exchange", m.Exchange)
+	dst = appendAttr(dst, "basecode", m.BaseCode)
+	dst = appendAttr(dst, "pointvalue", strconv.FormatFloat(m.PointValue, 'f', -1, 64))
+	dst = appendAttr(dst, "tickincrement", strconv.Itoa(m.TickIncrement))
+	dst = appendAttr(dst, "ddfexchange", m.DDFExchange)
+	dst = appendAttr(dst, "flag", m.Flag)
+	dst = appendAttr(dst, "lastupdate", formatTime(m.LastUpdate))
+
+	dst, err = appendPriceAttr(dst, "bid", m.Bid, bc)
+	if err != nil {
+		return dst, err
+	}
+	dst = appendAttr(dst, "bidsize", strconv.FormatInt(m.BidSize, 10))
+
+	dst, err = appendPriceAttr(dst, "ask", m.Ask, bc)
+	if err != nil {
+		return dst, err
+	}
+	dst = appendAttr(dst, "asksize", strconv.FormatInt(m.AskSize, 10))
+	dst = appendAttr(dst, "mode", m.Mode.Code())
+	dst = append(dst, '>')
+
+	// The combined and previous sessions are also in Sessions when the
+	// message came from Parse, so only write them separately otherwise
+	if _, ok := m.Sessions["combined"]; !ok && m.CurrentSession.ID != "" {
+		dst, err = appendSession(dst, m.CurrentSession, bc)
+		if err != nil {
+			return dst, err
+		}
+	}
+
+	if _, ok := m.Sessions["previous"]; !ok && m.PreviousSession.ID != "" {
+		dst, err = appendSession(dst, m.PreviousSession, bc)
+		if err != nil {
+			return dst, err
+		}
+	}
+
+	ids := make([]string, 0, len(m.Sessions))
+	for id := range m.Sessions {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		dst, err = appendSession(dst, m.Sessions[id], bc)
+		if err != nil {
+			return dst, err
+		}
+	}
+
+	return append(dst, "</QUOTE>"...), nil
+}
+
+// appendLevels appends the comma separated prices and sizes of book levels.
+func appendLevels(prices []byte, sizes []byte, levels []BookLevel, bc byte) ([]byte, []byte, error) {
+	var err error
+
+	for i, l := range levels {
+		if i > 0 {
+			prices = append(prices, ',')
+			sizes = append(sizes, ',')
+		}
+
+		prices, err = AppendFloat(prices, l.Price, bc)
+		if err != nil {
+			return prices, sizes, err
+		}
+		sizes = strconv.AppendInt(sizes, l.Size, 10)
+	}
+
+	return prices, sizes, nil
+}
+
+func appendBook(dst []byte, m MessageBook) ([]byte, error) {
+	if len(m.BaseCode) != 1 {
+		return dst, fmt.Errorf("invalid base code for %s", m.Symbol)
+	}
+	bc := m.BaseCode[0]
+
+	askPrices, askSizes, err := appendLevels(nil, nil, m.Asks, bc)
+	if err != nil {
+		return dst, err
+	}
+
+	bidPrices, bidSizes, err := appendLevels(nil, nil, m.Bids, bc)
+	if err != nil {
+		return dst, err
+	}
+
+	dst = append(dst, "%<BOOK"...)
+	dst = appendAttr(dst, "symbol", m.Symbol)
+	dst = appendAttr(dst, "basecode", m.BaseCode)
+	dst = appendAttr(dst, "askcount", strconv.Itoa(len(m.Asks)))
+	dst = appendAttr(dst, "bidcount", strconv.Itoa(len(m.Bids)))
+	dst = appendAttr(dst, "askprices", string(askPrices))
+	dst = appendAttr(dst, "asksizes", string(askSizes))
+	dst = appendAttr(dst, "bidprices", string(bidPrices))
+	dst = appendAttr(dst, "bidsizes", string(bidSizes))
+
+	return append(dst, "/>"...), nil
+}
+
+func appendCumulativeVolume(dst []byte, m MessageCumulativeVolume) ([]byte, error) {
+	if len(m.BaseCode) != 1 {
+		return dst, fmt.Errorf("invalid base code for %s", m.Symbol)
+	}
+	bc := m.BaseCode[0]
+
+	var (
+		data []byte
+		err  error
+	)
+
+	for i, l := range m.Levels {
+		if i > 0 {
+			data = append(data, ':')
+		}
+
+		data, err = AppendFloat(data, l.Price, bc)
+		if err != nil {
+			return dst, err
+		}
+		data = append(data, ',')
+		data = strconv.AppendInt(data, l.Volume, 10)
+	}
+
+	dst = append(dst, "%<CV"...)
+	dst = appendAttr(dst, "symbol", m.Symbol)
+	dst = appendAttr(dst, "basecode", m.BaseCode)
+	dst = appendAttr(dst, "tickincrement", strconv.Itoa(m.TickIncrement))
+	dst = appendAttr(dst, "data", string(data))
+
+	return append(dst, "/>"...), nil
+}
+
+// AppendMessage appends the wire format of m to dst. Trades, bid/asks,
+// timestamps, refreshes, books, cumulative volume and XML and raw
+// passthrough messages are supported.
+func AppendMessage(dst []byte, m Message) ([]byte, error) {
+	var err error
+
+	switch m := m.(type) {
+	case MessageTimestamp:
+		dst = append(dst, 1, '#')
+		dst = m.Timestamp.In(Location()).AppendFormat(dst, "20060102150405")
+		return append(dst, 3), nil
+
+	case MessageTrade:
+		dst, err = appendHeader(dst, m.Symbol, m.Info, '7')
+		if err != nil {
+			return dst, err
+		}
+
+		dst, err = AppendFloat(dst, m.Trade, m.Info.BaseCode[0])
+		if err != nil {
+			return dst, err
+		}
+		dst = append(dst, ',')
+		dst = strconv.AppendInt(dst, m.TradeSize, 10)
+		dst = append(dst, ',')
+
+		return appendTrailer(dst, m.Info, m.Timestamp)
+
+	case MessageBidAsk:
+		dst, err = appendHeader(dst, m.Symbol, m.Info, '8')
+		if err != nil {
+			return dst, err
+		}
+
+		dst, err = AppendFloat(dst, m.Bid, m.Info.BaseCode[0])
+		if err != nil {
+			return dst, err
+		}
+		dst = append(dst, ',')
+		dst = strconv.AppendInt(dst, m.BidSize, 10)
+		dst = append(dst, ',')
+
+		dst, err = AppendFloat(dst, m.Ask, m.Info.BaseCode[0])
+		if err != nil {
+			return dst, err
+		}
+		dst = append(dst, ',')
+		dst = strconv.AppendInt(dst, m.AskSize, 10)
+		dst = append(dst, ',')
+
+		return appendTrailer(dst, m.Info, m.Timestamp)
+
+	case MessageRefresh:
+		return appendRefresh(dst, m)
+
+	case MessageBook:
+		return appendBook(dst, m)
+
+	case MessageCumulativeVolume:
+		return appendCumulativeVolume(dst, m)
+
+	case MessageXML:
+		dst = append(dst, '%')
+		return append(dst, m.Data...), nil
+
+	case MessageRaw:
+		return append(dst, m.Data...), nil
+	}
+
+	return dst, fmt.Errorf("unsupported message type %v", m.Type())
+}
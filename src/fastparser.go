@@ -0,0 +1,182 @@
+// Go ddfplus API
+//
+// Copyright 2019 Barchart.com, Inc. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the GNU license
+// available at https://github.com/barchart/go-ddfplus-api/blob/master/LICENSE.
+package ddf
+
+import (
+	"bytes"
+	"errors"
+)
+
+var (
+	errNotTrade  = errors.New("not a trade message")
+	errNotBidAsk = errors.New("not a bid/ask message")
+)
+
+// Parser is the high throughput path for trade and bid/ask messages. Symbols
+// are interned, so a symbol string is only allocated the first time it is
+// seen, and messages can be decoded into structs owned by the caller.
+//
+// A Parser is not safe for concurrent use.
+type Parser struct {
+	symbols map[string]string
+}
+
+func NewParser() *Parser {
+	return &Parser{
+		symbols: make(map[string]string),
+	}
+}
+
+// Symbol returns the interned string for b.
+func (p *Parser) Symbol(b []byte) string {
+	// The compiler does not allocate for a string conversion used as a map key
+	if s, ok := p.symbols[string(b)]; ok {
+		return s
+	}
+
+	s := string(b)
+	p.symbols[s] = s
+	return s
+}
+
+// PeekType reports the type of message Parse would return for ba, without
+// decoding it. A trade or bid/ask that fails to decode is still reported as
+// a Trade or BidAsk, where Parse returns an error.
+func PeekType(ba []byte) MessageType {
+	if len(ba) < 10 {
+		return Raw
+	}
+
+	switch ba[0] {
+	case 1:
+		switch ba[1] {
+		case '#':
+			if len(ba) >= 16 {
+				if _, err := ParseTime(string(ba[2:16])); err == nil {
+					return Timestamp
+				}
+			}
+		case '2':
+			for i := 2; i+1 < len(ba); i++ {
+				if ba[i] == ',' {
+					switch ba[i+1] {
+					case '7':
+						return Trade
+					case '8':
+						return BidAsk
+					}
+					break
+				}
+			}
+		}
+	case '%':
+		if ba[1] == '<' {
+			return peekXML(ba[1:])
+		}
+	}
+
+	return Raw
+}
+
+// peekXML returns the type of the '%' payload ba from its root element, as
+// parseXML would.
+func peekXML(ba []byte) MessageType {
+	for {
+		i := bytes.IndexByte(ba, '<')
+		if i == -1 || i+1 == len(ba) {
+			return XML
+		}
+
+		// Skip any declaration or comment before the root
+		ba = ba[i+1:]
+		if ba[0] == '?' || ba[0] == '!' {
+			continue
+		}
+
+		end := bytes.IndexAny(ba, " \t\r\n/>")
+		if end == -1 {
+			end = len(ba)
+		}
+
+		switch string(ba[:end]) {
+		case "QUOTE":
+			return Refresh
+		case "BOOK":
+			return Book
+		case "CV":
+			return CumulativeVolume
+		}
+
+		return XML
+	}
+}
+
+// ParseTrade decodes a trade message into m.
+func (p *Parser) ParseTrade(ba []byte, m *MessageTrade) error {
+	if len(ba) < 2 || ba[0] != 1 || ba[1] != '2' {
+		return errNotTrade
+	}
+
+	sym, pos, info, err := parseHeader(ba)
+	if err != nil {
+		return err
+	}
+
+	if info.Subrecord != '7' {
+		return errNotTrade
+	}
+
+	*m = MessageTrade{}
+	m.Symbol = p.Symbol(sym)
+	m.Info = info
+	return decodeTrade(ba, pos, m)
+}
+
+// ParseBidAsk decodes a bid/ask message into m.
+func (p *Parser) ParseBidAsk(ba []byte, m *MessageBidAsk) error {
+	if len(ba) < 2 || ba[0] != 1 || ba[1] != '2' {
+		return errNotBidAsk
+	}
+
+	sym, pos, info, err := parseHeader(ba)
+	if err != nil {
+		return err
+	}
+
+	if info.Subrecord != '8' {
+		return errNotBidAsk
+	}
+
+	*m = MessageBidAsk{}
+	m.Symbol = p.Symbol(sym)
+	m.Info = info
+	return decodeBidAsk(ba, pos, m)
+}
+
+// Parse is the same as the package level Parse, with interned symbols for
+// trade and bid/ask messages.
+func (p *Parser) Parse(ba []byte) (Message, error) {
+	switch PeekType(ba) {
+	case Trade:
+		m := MessageTrade{}
+		err := p.ParseTrade(ba, &m)
+		if err != nil {
+			return nil, err
+		}
+		return m, nil
+
+	case BidAsk:
+		m := MessageBidAsk{}
+		err := p.ParseBidAsk(ba, &m)
+		if err != nil {
+			return nil, err
+		}
+		return m, nil
+	}
+
+	return Parse(ba)
+}
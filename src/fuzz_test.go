@@ -0,0 +1,162 @@
+// Go ddfplus API
+//
+// Copyright 2019 Barchart.com, Inc. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the GNU license
+// available at https://github.com/barchart/go-ddfplus-api/blob/master/LICENSE.
+package ddf
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func addGoldenSeeds(f *testing.F) {
+	for _, c := range loadGolden(f) {
+		ba, err := unescape(c.Input)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(ba)
+	}
+}
+
+// roundTrip encodes m and parses it back, returning false if m cannot be
+// encoded.
+func roundTrip(t *testing.T, m Message) (Message, []byte, bool) {
+	ba, err := AppendMessage(nil, m)
+	if err != nil {
+		return nil, nil, false
+	}
+
+	m2, err := Parse(ba)
+	if err != nil {
+		t.Fatalf("encoded %q does not parse. %v", ba, err)
+	}
+
+	return m2, ba, true
+}
+
+func FuzzParse(f *testing.F) {
+	addGoldenSeeds(f)
+
+	f.Fuzz(func(t *testing.T, ba []byte) {
+		m, err := Parse(ba)
+		if err != nil || m == nil {
+			return
+		}
+
+		switch m.Type() {
+		case Trade, BidAsk, Timestamp, Refresh, Book, CumulativeVolume:
+		default:
+			return
+		}
+
+		// Encoding normalizes the message, after which it must be stable
+		m1, ba1, ok := roundTrip(t, m)
+		if !ok {
+			return
+		}
+
+		m2, ba2, ok := roundTrip(t, m1)
+		if !ok {
+			t.Fatalf("%q re-encodes with an error", ba1)
+		}
+
+		if !reflect.DeepEqual(m1, m2) || !bytes.Equal(ba1, ba2) {
+			t.Fatalf("unstable round trip\n%q %+v\n%q %+v", ba1, m1, ba2, m2)
+		}
+	})
+}
+
+func FuzzParserParse(f *testing.F) {
+	addGoldenSeeds(f)
+
+	f.Fuzz(func(t *testing.T, ba []byte) {
+		m1, err1 := Parse(ba)
+		m2, err2 := NewParser().Parse(ba)
+
+		if (err1 == nil) != (err2 == nil) {
+			t.Fatalf("Parse error %v, Parser.Parse error %v", err1, err2)
+		}
+
+		if m1 == nil || m2 == nil || m1.Type() == Raw {
+			return
+		}
+
+		if !reflect.DeepEqual(m1, m2) {
+			t.Fatalf("Parse %+v, Parser.Parse %+v", m1, m2)
+		}
+	})
+}
+
+func FuzzParseFloat(f *testing.F) {
+	f.Add("306500", "A")
+	f.Add("-12", "A")
+	f.Add("3875", "2")
+	f.Add("12925", "5")
+	f.Add("-", "3")
+	f.Add("", "7")
+	f.Add("1234567", "F")
+
+	f.Fuzz(func(t *testing.T, s string, bc string) {
+		v, err := ParseFloat(s, bc)
+		if err != nil {
+			return
+		}
+
+		s1, err := FormatFloat(v, bc)
+		if err != nil {
+			return
+		}
+
+		v1, err := ParseFloat(s1, bc)
+		if err != nil {
+			t.Fatalf("%q formatted from %v does not parse. %v", s1, v, err)
+		}
+
+		if v1 != v {
+			t.Fatalf("%q (%v) formats as %q (%v)", s, v, s1, v1)
+		}
+	})
+}
+
+func FuzzParseTimestamp(f *testing.F) {
+	f.Add([]byte("\x03\x14\x53\x4b\x41\x49\x5e\x4f\xfa\x00"), 0)
+	f.Add([]byte("\x03\x14\x53\x4b\x41\x49\x5e\x4f"), 0)
+	f.Add([]byte("1G\x03\x15\x40\x41\x41\x40\x40\x40"), 2)
+
+	f.Fuzz(func(t *testing.T, ba []byte, etxpos int) {
+		ts, err := ParseTimestamp(ba, etxpos)
+		if err != nil {
+			return
+		}
+
+		ba1, err := AppendTimestamp(nil, ts)
+		if err != nil {
+			return
+		}
+
+		ts1, err := ParseTimestamp(ba1, 0)
+		if err != nil {
+			t.Fatalf("%q encoded from %v does not parse. %v", ba1, ts, err)
+		}
+
+		if !ts1.Equal(ts) {
+			t.Fatalf("%v encodes as %q (%v)", ts, ba1, ts1)
+		}
+	})
+}
+
+func FuzzParseSymbol(f *testing.F) {
+	f.Add("ESZ9")
+	f.Add("ESZ2019")
+	f.Add("ESZ3050C")
+	f.Add("^EURUSD")
+	f.Add("")
+
+	f.Fuzz(func(t *testing.T, s string) {
+		ParseSymbol(s)
+	})
+}
@@ -0,0 +1,244 @@
+// Go ddfplus API
+//
+// Copyright 2019 Barchart.com, Inc. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the GNU license
+// available at https://github.com/barchart/go-ddfplus-api/blob/master/LICENSE.
+package ddf
+
+import (
+	"sort"
+	"time"
+)
+
+// TradeRecord is one trade in the time and sales history.
+type TradeRecord struct {
+	Time  time.Time `json:"time"`
+	Price float64   `json:"price"`
+	Size  int64     `json:"size"`
+}
+
+// BidAskRecord is one change of the best bid or ask.
+type BidAskRecord struct {
+	Time    time.Time `json:"time"`
+	Bid     float64   `json:"bid"`
+	BidSize int64     `json:"bidsize"`
+	Ask     float64   `json:"ask"`
+	AskSize int64     `json:"asksize"`
+}
+
+// HistoryOptions sets how much history EnableHistory keeps.
+type HistoryOptions struct {
+	// Trades and Quotes are the number of trades and bid/ask changes kept
+	// per symbol. Zero keeps none.
+	Trades int
+	Quotes int
+
+	// MaxRecords limits the trades and bid/ask changes kept across all
+	// symbols. When it is reached, the oldest records of the symbols updated
+	// least recently are dropped first. Zero is no limit.
+	MaxRecords int
+}
+
+// history holds the ring buffers of one symbol. The oldest record is at
+// head, and the buffers grow up to their capacity before wrapping. Until a
+// buffer wraps its records run to the end of the slice, where the next one
+// is appended, so a buffer that empties starts over from the front.
+type history struct {
+	trades    []TradeRecord
+	tradeHead int
+	tradeLen  int
+
+	quotes    []BidAskRecord
+	quoteHead int
+	quoteLen  int
+
+	updated time.Time
+}
+
+// EnableHistory starts keeping trade and bid/ask history for every symbol.
+// Calling it again changes the options and clears the history.
+func (db *DB) EnableHistory(options HistoryOptions) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.historyOptions = options
+	db.history = make(map[string]*history)
+	db.historyRecords = 0
+}
+
+func (h *history) addTrade(r TradeRecord, capacity int) (added int) {
+	if capacity <= 0 {
+		return 0
+	}
+
+	if h.tradeLen < capacity {
+		if len(h.trades) < capacity {
+			h.trades = append(h.trades, r)
+		} else {
+			h.trades[(h.tradeHead+h.tradeLen)%capacity] = r
+		}
+		h.tradeLen++
+		return 1
+	}
+
+	h.trades[h.tradeHead] = r
+	h.tradeHead = (h.tradeHead + 1) % capacity
+	return 0
+}
+
+func (h *history) addQuote(r BidAskRecord, capacity int) (added int) {
+	if capacity <= 0 {
+		return 0
+	}
+
+	if h.quoteLen > 0 {
+		last := h.quotes[(h.quoteHead+h.quoteLen-1)%len(h.quotes)]
+		if last.Bid == r.Bid && last.BidSize == r.BidSize && last.Ask == r.Ask && last.AskSize == r.AskSize {
+			return 0
+		}
+	}
+
+	if h.quoteLen < capacity {
+		if len(h.quotes) < capacity {
+			h.quotes = append(h.quotes, r)
+		} else {
+			h.quotes[(h.quoteHead+h.quoteLen)%capacity] = r
+		}
+		h.quoteLen++
+		return 1
+	}
+
+	h.quotes[h.quoteHead] = r
+	h.quoteHead = (h.quoteHead + 1) % capacity
+	return 0
+}
+
+// dropOldest drops up to n of the oldest records, trades and bid/asks
+// alike, returning how many were dropped.
+func (h *history) dropOldest(n int) int {
+	dropped := 0
+	for dropped < n && (h.tradeLen > 0 || h.quoteLen > 0) {
+		dropTrade := h.quoteLen == 0
+		if h.tradeLen > 0 && h.quoteLen > 0 {
+			dropTrade = h.trades[h.tradeHead].Time.Before(h.quotes[h.quoteHead].Time)
+		}
+
+		if dropTrade {
+			h.tradeHead = (h.tradeHead + 1) % len(h.trades)
+			h.tradeLen--
+			if h.tradeLen == 0 {
+				h.trades, h.tradeHead = h.trades[:0], 0
+			}
+		} else {
+			h.quoteHead = (h.quoteHead + 1) % len(h.quotes)
+			h.quoteLen--
+			if h.quoteLen == 0 {
+				h.quotes, h.quoteHead = h.quotes[:0], 0
+			}
+		}
+		dropped++
+	}
+
+	return dropped
+}
+
+// record adds a trade or bid/ask change to the history. The DB lock must be
+// held.
+func (db *DB) record(symbol string, trade *TradeRecord, quote *BidAskRecord) {
+	if db.history == nil {
+		return
+	}
+
+	h := db.history[symbol]
+	if h == nil {
+		h = &history{}
+		db.history[symbol] = h
+	}
+
+	if trade != nil {
+		db.historyRecords += h.addTrade(*trade, db.historyOptions.Trades)
+		h.updated = trade.Time
+	}
+	if quote != nil {
+		db.historyRecords += h.addQuote(*quote, db.historyOptions.Quotes)
+		h.updated = quote.Time
+	}
+
+	if max := db.historyOptions.MaxRecords; max > 0 && db.historyRecords > max {
+		db.evictHistory(db.historyRecords - max + max/16)
+	}
+}
+
+// evictHistory drops n records, oldest first from the symbols updated least
+// recently. It frees a batch at a time so that the scan is not repeated on
+// every message once the limit is reached.
+func (db *DB) evictHistory(n int) {
+	symbols := make([]string, 0, len(db.history))
+	for s := range db.history {
+		symbols = append(symbols, s)
+	}
+	sort.Slice(symbols, func(i, j int) bool {
+		return db.history[symbols[i]].updated.Before(db.history[symbols[j]].updated)
+	})
+
+	for _, s := range symbols {
+		if n <= 0 {
+			break
+		}
+
+		h := db.history[s]
+		dropped := h.dropOldest(n)
+		n -= dropped
+		db.historyRecords -= dropped
+
+		if h.tradeLen == 0 && h.quoteLen == 0 {
+			delete(db.history, s)
+		}
+	}
+}
+
+// Trades returns the trades of symbol at or after since, oldest first. A
+// zero since returns all of them.
+func (db *DB) Trades(symbol string, since time.Time) []TradeRecord {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	h := db.history[symbol]
+	if h == nil {
+		return nil
+	}
+
+	var trades []TradeRecord
+	for i := 0; i < h.tradeLen; i++ {
+		r := h.trades[(h.tradeHead+i)%len(h.trades)]
+		if !r.Time.Before(since) {
+			trades = append(trades, r)
+		}
+	}
+
+	return trades
+}
+
+// QuoteHistory returns the last n bid/ask changes of symbol, oldest first. An
+// n of 0 or less returns all of them.
+func (db *DB) QuoteHistory(symbol string, n int) []BidAskRecord {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	h := db.history[symbol]
+	if h == nil {
+		return nil
+	}
+
+	if n <= 0 || n > h.quoteLen {
+		n = h.quoteLen
+	}
+
+	quotes := make([]BidAskRecord, n)
+	for i := range quotes {
+		quotes[i] = h.quotes[(h.quoteHead+h.quoteLen-n+i)%len(h.quotes)]
+	}
+
+	return quotes
+}
@@ -0,0 +1,115 @@
+// Go ddfplus API
+//
+// Copyright 2019 Barchart.com, Inc. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the GNU license
+// available at https://github.com/barchart/go-ddfplus-api/blob/master/LICENSE.
+package ddf
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHistory(t *testing.T) {
+	db := InitDB()
+	db.EnableHistory(HistoryOptions{Trades: 3, Quotes: 2})
+	db.Process(MessageRefresh{Symbol: "ESZ9", BaseCode: "A"})
+
+	start := time.Date(2019, 11, 6, 9, 30, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		ts := start.Add(time.Duration(i) * time.Second)
+		db.Process(MessageTrade{Symbol: "ESZ9", Trade: 3000 + float64(i), TradeSize: int64(i + 1), Timestamp: ts})
+		db.Process(MessageBidAsk{Symbol: "ESZ9", Bid: 3000 + float64(i), Ask: 3001 + float64(i), Timestamp: ts})
+	}
+
+	// An unchanged bid/ask is not a change
+	db.Process(MessageBidAsk{Symbol: "ESZ9", Bid: 3004, Ask: 3005, Timestamp: start.Add(time.Minute)})
+
+	trades := db.Trades("ESZ9", time.Time{})
+	if len(trades) != 3 || trades[0].Price != 3002 || trades[2].Price != 3004 || trades[2].Size != 5 {
+		t.Errorf("unexpected trades %+v", trades)
+	}
+
+	trades = db.Trades("ESZ9", start.Add(4*time.Second))
+	if len(trades) != 1 || trades[0].Price != 3004 {
+		t.Errorf("unexpected trades since %+v", trades)
+	}
+
+	quotes := db.QuoteHistory("ESZ9", 0)
+	if len(quotes) != 2 || quotes[0].Bid != 3003 || quotes[1].Bid != 3004 {
+		t.Errorf("unexpected quotes %+v", quotes)
+	}
+
+	quotes = db.QuoteHistory("ESZ9", 1)
+	if len(quotes) != 1 || quotes[0].Bid != 3004 {
+		t.Errorf("unexpected last quote %+v", quotes)
+	}
+
+	if db.Trades("CLZ9", time.Time{}) != nil {
+		t.Error("expected no trades for an unknown symbol")
+	}
+}
+
+func TestHistoryLimit(t *testing.T) {
+	db := InitDB()
+	db.EnableHistory(HistoryOptions{Trades: 100, MaxRecords: 32})
+
+	start := time.Date(2019, 11, 6, 9, 30, 0, 0, time.UTC)
+	for _, s := range []string{"CLZ9", "ESZ9"} {
+		db.Process(MessageRefresh{Symbol: s, BaseCode: "A"})
+		for i := 0; i < 20; i++ {
+			ts := start.Add(time.Duration(i) * time.Second)
+			if s == "ESZ9" {
+				ts = ts.Add(time.Minute)
+			}
+			db.Process(MessageTrade{Symbol: s, Trade: float64(i), TradeSize: 1, Timestamp: ts})
+		}
+	}
+
+	if db.historyRecords > 32 {
+		t.Errorf("history holds %d records", db.historyRecords)
+	}
+
+	// The symbol updated least recently loses its records first
+	if n := len(db.Trades("ESZ9", time.Time{})); n != 20 {
+		t.Errorf("expected all trades of the recent symbol, got %d", n)
+	}
+
+	trades := db.Trades("CLZ9", time.Time{})
+	if len(trades) != db.historyRecords-20 || (len(trades) > 0 && trades[len(trades)-1].Price != 19) {
+		t.Errorf("unexpected trades of the older symbol %+v", trades)
+	}
+}
+
+func TestHistoryDrained(t *testing.T) {
+	start := time.Date(2019, 11, 6, 9, 30, 0, 0, time.UTC)
+
+	var h history
+	for i := 0; i < 3; i++ {
+		h.addTrade(TradeRecord{Time: start.Add(time.Duration(i) * time.Second), Price: float64(i)}, 5)
+	}
+	h.addQuote(BidAskRecord{Time: start.Add(time.Minute), Bid: 1, Ask: 2}, 5)
+
+	// The trades are older than the bid/ask, so they go first
+	if dropped := h.dropOldest(3); dropped != 3 || h.tradeLen != 0 || h.quoteLen != 1 {
+		t.Fatalf("unexpected drop of %d, %d trades and %d quotes left", dropped, h.tradeLen, h.quoteLen)
+	}
+
+	h.addTrade(TradeRecord{Time: start.Add(2 * time.Minute), Price: 99}, 5)
+	h.addTrade(TradeRecord{Time: start.Add(3 * time.Minute), Price: 100}, 5)
+	if h.tradeLen != 2 || h.trades[h.tradeHead].Price != 99 || h.trades[(h.tradeHead+1)%len(h.trades)].Price != 100 {
+		t.Errorf("unexpected trades %+v from %d", h.trades, h.tradeHead)
+	}
+
+	// Drain the bid/asks, then check that a repeated bid/ask is still
+	// compared against the last one
+	h.dropOldest(1)
+	h.addQuote(BidAskRecord{Time: start.Add(4 * time.Minute), Bid: 3, Ask: 4}, 5)
+	if added := h.addQuote(BidAskRecord{Time: start.Add(5 * time.Minute), Bid: 3, Ask: 4}, 5); added != 0 {
+		t.Error("unchanged bid/ask added after draining")
+	}
+	if h.quoteLen != 1 || h.quotes[h.quoteHead].Bid != 3 {
+		t.Errorf("unexpected quotes %+v from %d", h.quotes, h.quoteHead)
+	}
+}
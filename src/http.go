@@ -0,0 +1,310 @@
+// Go ddfplus API
+//
+// Copyright 2019 Barchart.com, Inc. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the GNU license
+// available at https://github.com/barchart/go-ddfplus-api/blob/master/LICENSE.
+package ddf
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HTTPHandler serves quotes from a DB as JSON:
+//
+//	GET /quotes/{symbol}     a single quote
+//	GET /quotes?symbols=A,B  several quotes, with the symbols not found
+//	GET /symbols             the symbols in the DB
+//	GET /health              the feed timestamp and its age
+//
+// Every response carries the feed timestamp and its age in seconds in the
+// X-Feed-Timestamp and X-Feed-Age headers.
+type HTTPHandler struct {
+	db *DB
+
+	// MaxAge is how old the feed timestamp may get before /health reports
+	// the feed as stale. Zero disables the check.
+	MaxAge time.Duration
+
+	// SubscribeTimeout is how long a request for a symbol that is not in the
+	// DB waits for its refresh, when auto subscribing. Symbols without a
+	// refresh by then are unsubscribed.
+	SubscribeTimeout time.Duration
+
+	// MaxSubscriptions limits the symbols auto subscribed to, across all
+	// clients. Zero is no limit.
+	MaxSubscriptions int
+
+	// AllowSymbol reports whether a symbol may be auto subscribed to. nil
+	// allows any symbol.
+	AllowSymbol func(symbol string) bool
+
+	subscriber autoSubscriber
+}
+
+// autoSubscriber subscribes a Connection to symbols as they are asked for.
+type autoSubscriber struct {
+	mu         sync.Mutex
+	conn       *Connection
+	subscribed map[string]bool
+	updates    chan Message
+}
+
+type httpQuotes struct {
+	Timestamp time.Time `json:"timestamp"`
+	Quotes    []*Quote  `json:"quotes"`
+	Missing   []string  `json:"missing,omitempty"`
+}
+
+type httpHealth struct {
+	Status    string    `json:"status"`
+	Timestamp time.Time `json:"timestamp"`
+	Age       float64   `json:"age"`
+	Symbols   int       `json:"symbols"`
+}
+
+type httpError struct {
+	Error string `json:"error"`
+}
+
+func NewHTTPHandler(db *DB) *HTTPHandler {
+	return &HTTPHandler{
+		db:               db,
+		SubscribeTimeout: 2 * time.Second,
+		MaxSubscriptions: 1000,
+	}
+}
+
+func (a *autoSubscriber) set(conn *Connection) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.updates == nil {
+		// The connection only streams symbols that have a listener, while
+		// the DB gets its updates through RegisterMarketUpdateAll
+		a.updates = make(chan Message)
+		go func() {
+			for range a.updates {
+			}
+		}()
+	}
+
+	a.conn = conn
+	a.subscribed = make(map[string]bool)
+}
+
+// subscribe subscribes to the symbols not subscribed to before and not in
+// db, or only in db from a snapshot, returning them. Symbols that allow
+// rejects, or beyond max subscriptions, are left out.
+func (a *autoSubscriber) subscribe(db *DB, symbols []string, max int, allow func(string) bool) []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.conn == nil {
+		return nil
+	}
+
+	var added []string
+	for _, s := range symbols {
+		if max > 0 && len(a.subscribed) >= max {
+			break
+		}
+		if a.subscribed[s] || (allow != nil && !allow(s)) {
+			continue
+		}
+
+		if q := db.GetQuote(s); q == nil || q.Stale {
+			a.subscribed[s] = true
+			added = append(added, s)
+		}
+	}
+
+	if len(added) > 0 {
+		a.conn.RegisterMarketUpdate(added, a.updates)
+	}
+
+	return added
+}
+
+// expire unsubscribes from the symbols that have not had a refresh, so that
+// unknown symbols do not stay subscribed.
+func (a *autoSubscriber) expire(db *DB, symbols []string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.conn == nil {
+		return
+	}
+
+	var expired []string
+	for _, s := range symbols {
+		if q := db.GetQuote(s); a.subscribed[s] && (q == nil || q.Stale) {
+			delete(a.subscribed, s)
+			expired = append(expired, s)
+		}
+	}
+
+	if len(expired) > 0 {
+		a.conn.UnregisterMarketUpdate(expired, a.updates)
+	}
+}
+
+// AutoSubscribe subscribes conn to symbols the first time they are asked for
+// and are not already in the DB, or are stale. Stale quotes are served while
+// the refresh is on its way. The DB must be connected to conn.
+func (h *HTTPHandler) AutoSubscribe(conn *Connection) {
+	h.subscriber.set(conn)
+}
+
+// subscribe subscribes to the symbols not yet in the DB and waits for them to
+// arrive, for up to SubscribeTimeout.
+func (h *HTTPHandler) subscribe(symbols []string) {
+	added := h.subscriber.subscribe(h.db, symbols, h.MaxSubscriptions, h.AllowSymbol)
+	if len(added) == 0 {
+		return
+	}
+
+	// Register before looking at the DB, so that a refresh arriving in
+	// between is either in the DB or sent to updates
+	updates := make(chan *Quote, len(added))
+	h.db.Register(added, updates)
+
+	waiting := make(map[string]bool)
+	for _, s := range added {
+		if h.db.GetQuote(s) == nil {
+			waiting[s] = true
+		}
+	}
+
+	timeout := time.After(h.SubscribeTimeout)
+	for len(waiting) > 0 {
+		select {
+		case q := <-updates:
+			delete(waiting, q.Symbol)
+		case <-timeout:
+			waiting = nil
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-updates:
+			case <-done:
+				return
+			}
+		}
+	}()
+	h.db.Unregister(added, updates)
+	close(done)
+
+	h.subscriber.expire(h.db, added)
+}
+
+func (h *HTTPHandler) writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if ts := h.db.Timestamp(); !ts.IsZero() {
+		w.Header().Set("X-Feed-Timestamp", ts.Format(time.RFC3339))
+		w.Header().Set("X-Feed-Age", strconv.FormatFloat(time.Since(ts).Seconds(), 'f', 3, 64))
+	}
+	w.WriteHeader(status)
+
+	json.NewEncoder(w).Encode(v)
+}
+
+func (h *HTTPHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		h.writeJSON(w, http.StatusMethodNotAllowed, httpError{"method not allowed"})
+		return
+	}
+
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	switch {
+	case path == "/quotes":
+		h.serveQuotes(w, r)
+	case strings.HasPrefix(path, "/quotes/"):
+		h.serveQuote(w, path[len("/quotes/"):])
+	case path == "/symbols":
+		h.writeJSON(w, http.StatusOK, h.db.Symbols())
+	case path == "/health":
+		h.serveHealth(w)
+	default:
+		h.writeJSON(w, http.StatusNotFound, httpError{"not found"})
+	}
+}
+
+func (h *HTTPHandler) serveQuote(w http.ResponseWriter, symbol string) {
+	if symbol == "" {
+		h.writeJSON(w, http.StatusBadRequest, httpError{"missing symbol"})
+		return
+	}
+
+	q := h.db.GetQuote(symbol)
+	if q == nil {
+		h.subscribe([]string{symbol})
+		q = h.db.GetQuote(symbol)
+	}
+
+	if q == nil {
+		h.writeJSON(w, http.StatusNotFound, httpError{"unknown symbol " + symbol})
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, q)
+}
+
+func (h *HTTPHandler) serveQuotes(w http.ResponseWriter, r *http.Request) {
+	symbols := parseSymbolList(r.URL.Query().Get("symbols"))
+	if len(symbols) == 0 {
+		h.writeJSON(w, http.StatusBadRequest, httpError{"missing symbols"})
+		return
+	}
+
+	h.subscribe(symbols)
+
+	resp := httpQuotes{
+		Timestamp: h.db.Timestamp(),
+		Quotes:    make([]*Quote, 0, len(symbols)),
+	}
+	for _, s := range symbols {
+		if q := h.db.GetQuote(s); q != nil {
+			resp.Quotes = append(resp.Quotes, q)
+		} else {
+			resp.Missing = append(resp.Missing, s)
+		}
+	}
+
+	h.writeJSON(w, http.StatusOK, resp)
+}
+
+// serveHealth reports the feed as stale, with a 503, once the timestamp is
+// older than MaxAge or if none has been received.
+func (h *HTTPHandler) serveHealth(w http.ResponseWriter) {
+	resp := httpHealth{
+		Status:    "ok",
+		Timestamp: h.db.Timestamp(),
+		Symbols:   len(h.db.Symbols()),
+	}
+
+	status := http.StatusOK
+	if resp.Timestamp.IsZero() {
+		resp.Status = "no data"
+		status = http.StatusServiceUnavailable
+	} else {
+		age := time.Since(resp.Timestamp)
+		resp.Age = age.Seconds()
+		if h.MaxAge > 0 && age > h.MaxAge {
+			resp.Status = "stale"
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	h.writeJSON(w, status, resp)
+}
@@ -0,0 +1,156 @@
+// Go ddfplus API
+//
+// Copyright 2019 Barchart.com, Inc. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the GNU license
+// available at https://github.com/barchart/go-ddfplus-api/blob/master/LICENSE.
+package ddf
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHTTPHandler(t *testing.T) {
+	db := InitDB()
+	db.Process(MessageRefresh{Symbol: "ESZ9", BaseCode: "A", Bid: 3000.25, Ask: 3000.5})
+	db.Process(MessageRefresh{Symbol: "CLZ9", BaseCode: "A", Bid: 56.01, Ask: 56.02})
+
+	h := NewHTTPHandler(db)
+	h.MaxAge = time.Minute
+
+	get := func(path string, status int, v interface{}) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != status {
+			t.Fatalf("GET %s: expected status %d, got %d", path, status, w.Code)
+		}
+
+		if v != nil {
+			err := json.Unmarshal(w.Body.Bytes(), v)
+			if err != nil {
+				t.Fatalf("GET %s: %v", path, err)
+			}
+		}
+	}
+
+	var q Quote
+	get("/quotes/ESZ9", http.StatusOK, &q)
+	if q.Symbol != "ESZ9" || q.Data.CurrentSession.Bid != 3000.25 {
+		t.Errorf("unexpected quote %+v", q)
+	}
+
+	get("/quotes/NQZ9", http.StatusNotFound, nil)
+
+	var qs httpQuotes
+	get("/quotes?symbols=CLZ9,NQZ9", http.StatusOK, &qs)
+	if len(qs.Quotes) != 1 || qs.Quotes[0].Symbol != "CLZ9" || len(qs.Missing) != 1 || qs.Missing[0] != "NQZ9" {
+		t.Errorf("unexpected quotes %+v", qs)
+	}
+
+	var symbols []string
+	get("/symbols", http.StatusOK, &symbols)
+	if len(symbols) != 2 || symbols[0] != "CLZ9" || symbols[1] != "ESZ9" {
+		t.Errorf("unexpected symbols %v", symbols)
+	}
+
+	var health httpHealth
+	get("/health", http.StatusServiceUnavailable, &health)
+	if health.Status != "no data" {
+		t.Errorf("unexpected health %+v", health)
+	}
+
+	db.Process(MessageTimestamp{Timestamp: time.Now()})
+	get("/health", http.StatusOK, &health)
+	if health.Status != "ok" || health.Symbols != 2 {
+		t.Errorf("unexpected health %+v", health)
+	}
+
+	db.Process(MessageTimestamp{Timestamp: time.Now().Add(-time.Hour)})
+	get("/health", http.StatusServiceUnavailable, &health)
+	if health.Status != "stale" {
+		t.Errorf("unexpected health %+v", health)
+	}
+}
+
+func TestHTTPAutoSubscribe(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := NewServer()
+	server.OnSubscribe = func(c *ServerClient, symbols []string) {
+		for _, s := range symbols {
+			c.Send(MessageRefresh{Symbol: s, BaseCode: "A", Name: "E-Mini S&P 500", Bid: 3000.25, Ask: 3000.5})
+		}
+	}
+	go server.Serve(ln)
+	defer server.Close()
+
+	conn := NewConnectionTo(ln.Addr().String(), &Credentials{Username: "user"})
+	db := InitDB()
+	db.Connect(conn)
+	go conn.Start()
+
+	h := NewHTTPHandler(db)
+	h.SubscribeTimeout = 5 * time.Second
+	h.AutoSubscribe(conn)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/quotes/ESZ9", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	var q map[string]json.RawMessage
+	err = json.Unmarshal(w.Body.Bytes(), &q)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var info struct {
+		Name string `json:"name"`
+	}
+	json.Unmarshal(q["info"], &info)
+	if info.Name != "E-Mini S&P 500" {
+		t.Errorf("unexpected quote %s", w.Body.Bytes())
+	}
+}
+
+func TestHTTPSubscribeLimits(t *testing.T) {
+	conn := NewConnectionTo("127.0.0.1:0", &Credentials{Username: "user"})
+	db := InitDB()
+	db.Process(MessageRefresh{Symbol: "GCZ9", BaseCode: "A"})
+
+	h := NewHTTPHandler(db)
+	h.SubscribeTimeout = 10 * time.Millisecond
+	h.MaxSubscriptions = 2
+	h.AllowSymbol = func(symbol string) bool {
+		return !strings.HasPrefix(symbol, "CL")
+	}
+	h.AutoSubscribe(conn)
+
+	added := h.subscriber.subscribe(db, []string{"GCZ9", "CLZ9", "ESZ9", "NQZ9", "YMZ9"}, h.MaxSubscriptions, h.AllowSymbol)
+	if strings.Join(added, ",") != "ESZ9,NQZ9" {
+		t.Errorf("unexpected subscriptions %v", added)
+	}
+
+	// Symbols without a refresh are unsubscribed, making room for others
+	h.subscriber.expire(db, added)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/quotes/YMZ9", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+
+	if len(h.subscriber.subscribed) != 0 || len(conn.marketUpdateChannels) != 0 {
+		t.Errorf("unexpected subscriptions %v %v", h.subscriber.subscribed, conn.marketUpdateChannels)
+	}
+}
@@ -6,7 +6,10 @@
 // available at https://github.com/barchart/go-ddfplus-api/blob/master/LICENSE.
 package ddf
 
-import "time"
+import (
+	"sort"
+	"time"
+)
 
 type MessageType int
 
@@ -15,6 +18,10 @@ const (
 	Refresh
 	Timestamp
 	Trade
+	Book
+	CumulativeVolume
+	XML
+	Raw
 )
 
 type DDFMessageInfo struct {
@@ -27,6 +34,17 @@ type DDFMessageInfo struct {
 	Session   byte
 }
 
+// TradingDate resolves the day code against ref, returning the zero time if
+// the day code is invalid.
+func (i DDFMessageInfo) TradingDate(ref time.Time) time.Time {
+	t, _ := TradingDate(i.DayCode, ref)
+	return t
+}
+
+func (i DDFMessageInfo) SessionKind() SessionKind {
+	return ParseSessionKind(i.Session)
+}
+
 type Message interface {
 	Type() MessageType
 }
@@ -45,61 +63,222 @@ func (m MessageBidAsk) Type() MessageType {
 	return BidAsk
 }
 
-type MessageRefresh struct {
-	Symbol         string
-	Name           string
-	Exchange       string
-	BaseCode       string
-	PointValue     float64
-	TickIncrement  int
-	DDFExchange    string
-	LastUpdate     time.Time
-	Bid            float64
-	BidSize        int64
-	Ask            float64
-	AskSize        int64
-	CurrentSession struct {
-		Day          string
-		Session      string
-		Timestamp    time.Time
-		Open         float64
-		High         float64
-		Low          float64
-		Last         float64
-		Previous     float64
-		Settlement   float64
-		TradeSize    int64
-		Volume       int64
-		OpenInterest int64
-		NumTrades    int64
-		PriceVolume  float64
-		TradeTime    time.Time
-		Ticks        string
+func (m MessageBidAsk) TradingDate() time.Time {
+	return m.Info.TradingDate(m.Timestamp)
+}
+
+func (m MessageBidAsk) SessionKind() SessionKind {
+	return m.Info.SessionKind()
+}
+
+type QuoteMode int
+
+const (
+	ModeUnknown QuoteMode = iota
+	ModeRealtime
+	ModeDelayed
+	ModeEndOfDay
+)
+
+func (m QuoteMode) String() string {
+	switch m {
+	case ModeRealtime:
+		return "realtime"
+	case ModeDelayed:
+		return "delayed"
+	case ModeEndOfDay:
+		return "end-of-day"
+	}
+
+	return "unknown"
+}
+
+// Code returns the mode attribute value for m.
+func (m QuoteMode) Code() string {
+	switch m {
+	case ModeRealtime:
+		return "R"
+	case ModeDelayed:
+		return "I"
+	case ModeEndOfDay:
+		return "D"
+	}
+
+	return ""
+}
+
+// ParseQuoteMode maps the mode attribute of a refresh message to a quote mode.
+func ParseQuoteMode(s string) QuoteMode {
+	switch s {
+	case "R":
+		return ModeRealtime
+	case "I":
+		return ModeDelayed
+	case "D":
+		return ModeEndOfDay
 	}
-	PreviousSession struct {
-		Day          string
-		Session      string
-		Timestamp    time.Time
-		Open         float64
-		High         float64
-		Low          float64
-		Last         float64
-		Previous     float64
-		Settlement   float64
-		TradeSize    int64
-		Volume       int64
-		OpenInterest int64
-		NumTrades    int64
-		PriceVolume  float64
-		TradeTime    time.Time
-		Ticks        string
+
+	return ModeUnknown
+}
+
+type TickDirection int
+
+const (
+	TickUnknown TickDirection = iota
+	TickUp
+	TickDown
+	TickZeroUp
+	TickZeroDown
+)
+
+func (t TickDirection) String() string {
+	switch t {
+	case TickUp:
+		return "up"
+	case TickDown:
+		return "down"
+	case TickZeroUp:
+		return "zero-up"
+	case TickZeroDown:
+		return "zero-down"
+	}
+
+	return "unknown"
+}
+
+// Code returns a ticks attribute value that parses back to t.
+func (t TickDirection) Code() string {
+	switch t {
+	case TickUp:
+		return "+"
+	case TickDown:
+		return "-"
+	case TickZeroUp:
+		return "+."
+	case TickZeroDown:
+		return "-."
 	}
+
+	return ""
+}
+
+// ParseTicks converts the ticks attribute of a refresh session, where the
+// last character is the latest tick ('+', '-' or '.' when unchanged) and the
+// one before it the tick prior to that.
+func ParseTicks(s string) TickDirection {
+	for i := len(s) - 1; i >= 0; i-- {
+		switch s[i] {
+		case '+':
+			if i == len(s)-1 {
+				return TickUp
+			}
+			return TickZeroUp
+		case '-':
+			if i == len(s)-1 {
+				return TickDown
+			}
+			return TickZeroDown
+		}
+	}
+
+	return TickUnknown
+}
+
+type RefreshSession struct {
+	ID           string
+	Day          string
+	Session      string
+	Timestamp    time.Time
+	Open         float64
+	High         float64
+	Low          float64
+	Last         float64
+	Previous     float64
+	Settlement   float64
+	TradeSize    int64
+	Volume       int64
+	OpenInterest int64
+	NumTrades    int64
+	PriceVolume  float64
+	TradeTime    time.Time
+	Ticks        TickDirection
+}
+
+func (s RefreshSession) TradingDate() time.Time {
+	t, _ := TradingDate(firstByte(s.Day), s.Timestamp)
+	return t
+}
+
+func (s RefreshSession) SessionKind() SessionKind {
+	return ParseSessionKind(firstByte(s.Session))
+}
+
+type MessageRefresh struct {
+	Symbol          string
+	Name            string
+	Exchange        string
+	BaseCode        string
+	PointValue      float64
+	TickIncrement   int
+	DDFExchange     string
+	Flag            string
+	Mode            QuoteMode
+	LastUpdate      time.Time
+	Bid             float64
+	BidSize         int64
+	Ask             float64
+	AskSize         int64
+	CurrentSession  RefreshSession
+	PreviousSession RefreshSession
+	Sessions        map[string]RefreshSession
 }
 
 func (m MessageRefresh) Type() MessageType {
 	return Refresh
 }
 
+func (m MessageRefresh) TradingDate() time.Time {
+	ref := m.CurrentSession.Timestamp
+	if ref.IsZero() {
+		ref = m.LastUpdate
+	}
+
+	t, _ := TradingDate(firstByte(m.CurrentSession.Day), ref)
+	return t
+}
+
+func (m MessageRefresh) SessionKind() SessionKind {
+	return m.CurrentSession.SessionKind()
+}
+
+// SessionByKind returns the trading session (not the combined or previous
+// summary) of the given kind, such as the electronic or pit session. Of
+// several sessions of the kind, the latest wins, then the first by id.
+func (m MessageRefresh) SessionByKind(kind SessionKind) (RefreshSession, bool) {
+	ids := make([]string, 0, len(m.Sessions))
+	for id := range m.Sessions {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var (
+		found RefreshSession
+		ok    bool
+	)
+	for _, id := range ids {
+		session := m.Sessions[id]
+		if id == "combined" || id == "previous" || session.SessionKind() != kind {
+			continue
+		}
+
+		if !ok || session.Timestamp.After(found.Timestamp) {
+			found, ok = session, true
+		}
+	}
+
+	return found, ok
+}
+
 type MessageTimestamp struct {
 	Timestamp time.Time
 }
@@ -119,3 +298,68 @@ type MessageTrade struct {
 func (m MessageTrade) Type() MessageType {
 	return Trade
 }
+
+func (m MessageTrade) TradingDate() time.Time {
+	return m.Info.TradingDate(m.Timestamp)
+}
+
+func (m MessageTrade) SessionKind() SessionKind {
+	return m.Info.SessionKind()
+}
+
+type BookLevel struct {
+	Price float64
+	Size  int64
+}
+
+type MessageBook struct {
+	Symbol   string
+	BaseCode string
+	Bids     []BookLevel
+	Asks     []BookLevel
+}
+
+func (m MessageBook) Type() MessageType {
+	return Book
+}
+
+type VolumeLevel struct {
+	Price  float64
+	Volume int64
+}
+
+type MessageCumulativeVolume struct {
+	Symbol        string
+	BaseCode      string
+	TickIncrement int
+	Levels        []VolumeLevel
+}
+
+func (m MessageCumulativeVolume) Type() MessageType {
+	return CumulativeVolume
+}
+
+// MessageXML carries a '%' XML payload whose root element is not understood,
+// so that nothing the server sends is silently dropped.
+type MessageXML struct {
+	Root   string
+	Symbol string
+	Data   []byte
+}
+
+func (m MessageXML) Type() MessageType {
+	return XML
+}
+
+// MessageRaw is any message Parse does not understand, kept as received.
+type MessageRaw struct {
+	Record    byte
+	Subrecord byte
+	Symbol    string
+	Data      []byte
+	Received  time.Time
+}
+
+func (m MessageRaw) Type() MessageType {
+	return Raw
+}
@@ -12,14 +12,62 @@ import (
 	"fmt"
 	"log"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 )
 
+// DefaultLocation is the time zone DDF timestamps are reported in when no
+// other location has been set.
+const DefaultLocation = "America/Chicago"
+
+// location holds the *time.Location of DDF timestamps. It is read by every
+// parse, so SetLocation may race with a running Connection.
+var location atomic.Value
+
+func init() {
+	location.Store(loadLocation(DefaultLocation))
+}
+
+func loadLocation(name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		log.Printf("Unable to load location %s, using UTC. %v", name, err)
+		return time.UTC
+	}
+
+	return loc
+}
+
+// SetLocation sets the time zone used when parsing DDF timestamps. A nil
+// location restores the default.
+func SetLocation(loc *time.Location) {
+	if loc == nil {
+		loc = loadLocation(DefaultLocation)
+	}
+	location.Store(loc)
+}
+
+// Location returns the time zone used when parsing DDF timestamps.
+func Location() *time.Location {
+	return location.Load().(*time.Location)
+}
+
+// ParseTime parses a "20060102150405" formatted DDF time string in the
+// configured location.
+func ParseTime(s string) (time.Time, error) {
+	return time.ParseInLocation("20060102150405", s, Location())
+}
+
 func ParseTimestamp(ba []byte, etxpos int) (time.Time, error) {
 	var (
 		t time.Time
 	)
 
+	if etxpos < 0 {
+		return t, fmt.Errorf("invalid <ETX> position %d", etxpos)
+	}
+
 	st := etxpos + 1
 	xlen := len(ba) - st
 	if xlen != 7 && xlen != 9 {
@@ -30,9 +78,6 @@ func ParseTimestamp(ba []byte, etxpos int) (time.Time, error) {
 		return t, fmt.Errorf("invalid char when <ETX> expected. %d", ba[etxpos])
 	}
 
-	if ba[st] != 20 {
-		return t, fmt.Errorf("invalid century %d. Should be 20", ba[st])
-	}
 	year := int(ba[st])*100 + int(ba[st+1]-64)
 	month := int(ba[st+2] - 64)
 	date := int(ba[st+3] - 64)
@@ -43,7 +88,7 @@ func ParseTimestamp(ba []byte, etxpos int) (time.Time, error) {
 	if xlen == 9 {
 		ms = int((0xFF & ba[st+7])) + ((0xFF & int(ba[st+8])) << 8)
 	}
-	t = time.Date(year, time.Month(month), date, hour, minute, second, ms*1000, time.UTC)
+	t = time.Date(year, time.Month(month), date, hour, minute, second, ms*int(time.Millisecond), Location())
 
 	return t, nil
 }
@@ -54,16 +99,18 @@ func Parse(ba []byte) (Message, error) {
 	}
 
 	if len(ba) < 10 {
-		log.Printf("Malformed message? %s\n", string(ba))
-		log.Println(ba)
-		return nil, nil
+		return ParseRaw(ba), nil
 	}
 
 	switch ba[0] {
 	case 1: // DDF message
 		switch ba[1] { // Record
 		case '#': // Timestamp
-			t, err := time.Parse("20060102150405", string(ba[2:16]))
+			if len(ba) < 16 {
+				break
+			}
+
+			t, err := ParseTime(string(ba[2:16]))
 			if err == nil {
 				m := MessageTimestamp{}
 				m.Timestamp = t
@@ -71,212 +118,414 @@ func Parse(ba []byte) (Message, error) {
 
 			}
 		case '2': //
-			info := DDFMessageInfo{}
-			info.Record = ba[1] // Record
-
-			// Find the comma
-			i := bytes.IndexByte(ba, ',')
-			if i == -1 {
-				return nil, fmt.Errorf("no comma in type 2")
+			sym, pos, info, err := parseHeader(ba)
+			if err != nil {
+				return nil, err
 			}
 
-			pos := i
-
-			sym := string(ba[2:pos])
-			info.Subrecord = ba[pos+1]
-			info.BaseCode = string(ba[pos+3])
-			info.Exchange = string(ba[pos+4])
-			info.Delay, _ = strconv.Atoi(string(ba[pos+5 : pos+7]))
-
-			switch ba[pos+1] {
+			switch info.Subrecord {
 			case '7': // Trades
 				m := MessageTrade{}
-				m.Symbol = sym
-
-				pos += 7
-				i := bytes.IndexByte(ba[pos:], ',')
-				if i == -1 {
-					return nil, fmt.Errorf("missing comma for trade")
+				m.Symbol = string(sym)
+				m.Info = info
+				err = decodeTrade(ba, pos, &m)
+				if err != nil {
+					return nil, err
 				}
-				m.Trade, _ = ParseFloat(string(ba[pos:pos+i]), info.BaseCode)
+				return m, nil
 
-				pos += i + 1
-				i = bytes.IndexByte(ba[pos:], ',')
-				if i == -1 {
-					return nil, fmt.Errorf("missing comma for trade size")
+			case '8': // Bid/Ask
+				m := MessageBidAsk{}
+				m.Symbol = string(sym)
+				m.Info = info
+				err = decodeBidAsk(ba, pos, &m)
+				if err != nil {
+					return nil, err
 				}
-				m.TradeSize, _ = strconv.ParseInt(string(ba[pos:pos+i]), 10, 64)
+				return m, nil
+			}
+		}
+	case 37: // '%' Refresh Message
+		if ba[1] == '<' {
+			return parseXML(ba[1:])
+		}
+	}
 
-				pos += i + 1
+	return ParseRaw(ba), nil
+}
 
-				info.DayCode = ba[pos]
-				info.Session = ba[pos+1]
+// parseHeader reads the symbol and record header of a type 2 message. pos is
+// the index of the first field after the header.
+func parseHeader(ba []byte) (sym []byte, pos int, info DDFMessageInfo, err error) {
+	info.Record = ba[1]
 
-				m.Timestamp, _ = ParseTimestamp(ba, pos+2)
+	// Find the comma
+	i := bytes.IndexByte(ba, ',')
+	if i == -1 {
+		return nil, 0, info, fmt.Errorf("no comma in type 2")
+	}
 
-				m.Info = info
-				return m, nil
+	if i+7 > len(ba) {
+		return nil, 0, info, fmt.Errorf("truncated header in type 2")
+	}
 
-			case '8': // Bid/Ask
-				m := MessageBidAsk{}
-				m.Symbol = sym
+	sym = ba[2:i]
+	info.Subrecord = ba[i+1]
+	info.BaseCode = string(ba[i+3 : i+4])
+	info.Exchange = string(ba[i+4 : i+5])
+	delay, _ := parseInt(ba[i+5 : i+7])
+	info.Delay = int(delay)
 
-				pos += 7
-				i := bytes.IndexByte(ba[pos:], ',')
-				if i == -1 {
-					return nil, fmt.Errorf("missing comma for bid")
-				}
-				m.Bid, _ = ParseFloat(string(ba[pos:pos+i]), info.BaseCode)
+	return sym, i + 7, info, nil
+}
 
-				pos += i + 1
-				i = bytes.IndexByte(ba[pos:], ',')
-				if i == -1 {
-					return nil, fmt.Errorf("missing comma for bid size")
-				}
-				m.BidSize, _ = strconv.ParseInt(string(ba[pos:pos+i]), 10, 64)
+// nextField returns the field starting at pos and the position after its
+// terminating comma, or -1 if there is no comma.
+func nextField(ba []byte, pos int) ([]byte, int) {
+	i := bytes.IndexByte(ba[pos:], ',')
+	if i == -1 {
+		return nil, -1
+	}
 
-				pos += i + 1
-				i = bytes.IndexByte(ba[pos:], ',')
-				if i == -1 {
-					return nil, fmt.Errorf("missing comma for ask")
-				}
-				m.Ask, _ = ParseFloat(string(ba[pos:pos+i]), info.BaseCode)
+	return ba[pos : pos+i], pos + i + 1
+}
 
-				pos += i + 1
-				i = bytes.IndexByte(ba[pos:], ',')
-				if i == -1 {
-					return nil, fmt.Errorf("missing comma for ask size")
-				}
-				m.AskSize, _ = strconv.ParseInt(string(ba[pos:pos+i]), 10, 64)
-				pos += i + 1
+// decodeTrailer reads the day code, session and timestamp that end a type 2
+// message.
+func decodeTrailer(ba []byte, pos int, info *DDFMessageInfo) time.Time {
+	if pos+1 >= len(ba) {
+		return time.Time{}
+	}
 
-				info.DayCode = ba[pos]
-				info.Session = ba[pos+1]
+	info.DayCode = ba[pos]
+	info.Session = ba[pos+1]
 
-				m.Timestamp, _ = ParseTimestamp(ba, pos+2)
+	t, _ := ParseTimestamp(ba, pos+2)
+	return t
+}
 
-				m.Info = info
-				return m, nil
-			}
+func decodeTrade(ba []byte, pos int, m *MessageTrade) error {
+	bc := ba[pos-4]
+
+	field, pos := nextField(ba, pos)
+	if pos == -1 {
+		return fmt.Errorf("missing comma for trade")
+	}
+	m.Trade, _ = ParseFloatBytes(field, bc)
+
+	field, pos = nextField(ba, pos)
+	if pos == -1 {
+		return fmt.Errorf("missing comma for trade size")
+	}
+	m.TradeSize, _ = parseInt(field)
+
+	m.Timestamp = decodeTrailer(ba, pos, &m.Info)
+	return nil
+}
+
+func decodeBidAsk(ba []byte, pos int, m *MessageBidAsk) error {
+	bc := ba[pos-4]
+
+	field, pos := nextField(ba, pos)
+	if pos == -1 {
+		return fmt.Errorf("missing comma for bid")
+	}
+	m.Bid, _ = ParseFloatBytes(field, bc)
+
+	field, pos = nextField(ba, pos)
+	if pos == -1 {
+		return fmt.Errorf("missing comma for bid size")
+	}
+	m.BidSize, _ = parseInt(field)
+
+	field, pos = nextField(ba, pos)
+	if pos == -1 {
+		return fmt.Errorf("missing comma for ask")
+	}
+	m.Ask, _ = ParseFloatBytes(field, bc)
+
+	field, pos = nextField(ba, pos)
+	if pos == -1 {
+		return fmt.Errorf("missing comma for ask size")
+	}
+	m.AskSize, _ = parseInt(field)
+
+	m.Timestamp = decodeTrailer(ba, pos, &m.Info)
+	return nil
+}
+
+// ParseRaw wraps a message Parse does not understand. The record, subrecord
+// and symbol are filled in when ba looks like a DDF record.
+func ParseRaw(ba []byte) MessageRaw {
+	m := MessageRaw{}
+	m.Data = append([]byte(nil), ba...)
+	m.Received = time.Now()
+
+	if len(ba) < 2 || ba[0] != 1 {
+		return m
+	}
+
+	m.Record = ba[1]
+
+	i := bytes.IndexByte(ba, ',')
+	if i > 2 {
+		m.Symbol = string(ba[2:i])
+		if i+1 < len(ba) {
+			m.Subrecord = ba[i+1]
 		}
-	case 37: // '%' Refresh Message
-		if ba[1] == '<' {
-			type XMLSession struct {
-				XMLName      xml.Name `xml:"SESSION"`
-				ID           string   `xml:"id,attr"`
-				Day          string   `xml:"day,attr"`
-				Session      string   `xml:"session,attr"`
-				Timestamp    string   `xml:"timestamp,attr"`
-				Open         string   `xml:"open,attr"`
-				High         string   `xml:"high,attr"`
-				Low          string   `xml:"low,attr"`
-				Last         string   `xml:"last,attr"`
-				Previous     string   `xml:"previous,attr"`
-				Settlement   string   `xml:"settlement,attr"`
-				TradeSize    string   `xml:"tradesize,attr"`
-				Volume       string   `xml:"volume,attr"`
-				OpenInterest string   `xml:"openinterest,attr"`
-				NumTrades    string   `xml:"numtrades,attr"`
-				PriceVolume  string   `xml:"pricevolume,attr"`
-				TradeTime    string   `xml:"tradetime,attr"`
-				Ticks        string   `xml:"ticks,attr"`
+	}
+
+	return m
+}
+
+type xmlSession struct {
+	XMLName      xml.Name `xml:"SESSION"`
+	ID           string   `xml:"id,attr"`
+	Day          string   `xml:"day,attr"`
+	Session      string   `xml:"session,attr"`
+	Timestamp    string   `xml:"timestamp,attr"`
+	Open         string   `xml:"open,attr"`
+	High         string   `xml:"high,attr"`
+	Low          string   `xml:"low,attr"`
+	Last         string   `xml:"last,attr"`
+	Previous     string   `xml:"previous,attr"`
+	Settlement   string   `xml:"settlement,attr"`
+	TradeSize    string   `xml:"tradesize,attr"`
+	Volume       string   `xml:"volume,attr"`
+	OpenInterest string   `xml:"openinterest,attr"`
+	NumTrades    string   `xml:"numtrades,attr"`
+	PriceVolume  string   `xml:"pricevolume,attr"`
+	TradeTime    string   `xml:"tradetime,attr"`
+	Ticks        string   `xml:"ticks,attr"`
+}
+
+type xmlQuote struct {
+	XMLName       xml.Name     `xml:"QUOTE"`
+	Sessions      []xmlSession `xml:"SESSION"`
+	Symbol        string       `xml:"symbol,attr"`
+	Name          string       `xml:"name,attr"`
+	Exchange      string       `xml:"exchange,attr"`
+	BaseCode      string       `xml:"basecode,attr"`
+	PointValue    string       `xml:"pointvalue,attr"`
+	TickIncrement string       `xml:"tickincrement,attr"`
+	DDFExchange   string       `xml:"ddfexchange,attr"`
+	Flag          string       `xml:"flag,attr"`
+	LastUpdate    string       `xml:"lastupdate,attr"`
+	Bid           string       `xml:"bid,attr"`
+	BidSize       string       `xml:"bidsize,attr"`
+	Ask           string       `xml:"ask,attr"`
+	AskSize       string       `xml:"asksize,attr"`
+	Mode          string       `xml:"mode,attr"`
+}
+
+type xmlBook struct {
+	XMLName   xml.Name `xml:"BOOK"`
+	Symbol    string   `xml:"symbol,attr"`
+	BaseCode  string   `xml:"basecode,attr"`
+	AskCount  string   `xml:"askcount,attr"`
+	AskPrices string   `xml:"askprices,attr"`
+	AskSizes  string   `xml:"asksizes,attr"`
+	BidCount  string   `xml:"bidcount,attr"`
+	BidPrices string   `xml:"bidprices,attr"`
+	BidSizes  string   `xml:"bidsizes,attr"`
+}
+
+type xmlCumulativeVolume struct {
+	XMLName       xml.Name `xml:"CV"`
+	Symbol        string   `xml:"symbol,attr"`
+	BaseCode      string   `xml:"basecode,attr"`
+	TickIncrement string   `xml:"tickincrement,attr"`
+	Data          string   `xml:"data,attr"`
+}
+
+// parseXML dispatches a '%' payload on its root element.
+func parseXML(ba []byte) (Message, error) {
+	d := xml.NewDecoder(bytes.NewReader(ba))
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		se, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		switch se.Name.Local {
+		case "QUOTE":
+			return parseQuote(ba)
+		case "BOOK":
+			return parseBook(ba)
+		case "CV":
+			return parseCumulativeVolume(ba)
+		}
+
+		m := MessageXML{}
+		m.Root = se.Name.Local
+		for _, attr := range se.Attr {
+			if attr.Name.Local == "symbol" {
+				m.Symbol = attr.Value
 			}
+		}
+		m.Data = append([]byte(nil), ba...)
+		return m, nil
+	}
+}
+
+func parseQuote(ba []byte) (Message, error) {
+	var q xmlQuote
+	err := xml.Unmarshal(ba, &q)
+	if err != nil {
+		return nil, err
+	}
 
-			type XMLQuote struct {
-				XMLName       xml.Name     `xml:"QUOTE"`
-				Sessions      []XMLSession `xml:"SESSION"`
-				Symbol        string       `xml:"symbol,attr"`
-				Name          string       `xml:"name,attr"`
-				Exchange      string       `xml:"exchange,attr"`
-				BaseCode      string       `xml:"basecode,attr"`
-				PointValue    string       `xml:"pointvalue,attr"`
-				TickIncrement string       `xml:"tickincrement,attr"`
-				DDFExchange   string       `xml:"ddfexchange,attr"`
-				Flag          string       `xml:"flag,attr"`
-				LastUpdate    string       `xml:"lastupdate,attr"`
-				Bid           string       `xml:"bid,attr"`
-				BidSize       string       `xml:"bidsize,attr"`
-				Ask           string       `xml:"ask,attr"`
-				AskSize       string       `xml:"asksize,attr"`
-				Mode          string       `xml:"mode,attr"`
+	// Attributes are optional, so conversion errors just leave the zero value
+	m := MessageRefresh{}
+	m.Symbol = q.Symbol
+	m.Name = q.Name
+	m.Exchange = q.Exchange
+	m.DDFExchange = q.DDFExchange
+	m.BaseCode = q.BaseCode
+	m.Flag = q.Flag
+	m.Mode = ParseQuoteMode(q.Mode)
+	m.TickIncrement, _ = strconv.Atoi(q.TickIncrement)
+	m.PointValue, _ = strconv.ParseFloat(q.PointValue, 64)
+	m.LastUpdate, _ = ParseTime(q.LastUpdate)
+
+	m.Bid, _ = ParseFloat(q.Bid, q.BaseCode)
+	m.BidSize, _ = strconv.ParseInt(q.BidSize, 10, 64)
+	m.Ask, _ = ParseFloat(q.Ask, q.BaseCode)
+	m.AskSize, _ = strconv.ParseInt(q.AskSize, 10, 64)
+
+	m.Sessions = make(map[string]RefreshSession, len(q.Sessions))
+	for _, xs := range q.Sessions {
+		session := parseSession(xs, q.BaseCode)
+		m.Sessions[session.ID] = session
+
+		switch session.ID {
+		case "combined":
+			m.CurrentSession = session
+		case "previous":
+			m.PreviousSession = session
+		}
+	}
+
+	return m, nil
+}
+
+func parseSession(xs xmlSession, baseCode string) RefreshSession {
+	var session RefreshSession
+
+	session.ID = xs.ID
+	session.Day = xs.Day
+	session.Session = xs.Session
+	session.Timestamp, _ = ParseTime(xs.Timestamp)
+	session.Open, _ = ParseFloat(xs.Open, baseCode)
+	session.High, _ = ParseFloat(xs.High, baseCode)
+	session.Low, _ = ParseFloat(xs.Low, baseCode)
+	session.Last, _ = ParseFloat(xs.Last, baseCode)
+	session.Previous, _ = ParseFloat(xs.Previous, baseCode)
+	session.Settlement, _ = ParseFloat(xs.Settlement, baseCode)
+	session.TradeSize, _ = strconv.ParseInt(xs.TradeSize, 10, 64)
+	session.Volume, _ = strconv.ParseInt(xs.Volume, 10, 64)
+	session.OpenInterest, _ = strconv.ParseInt(xs.OpenInterest, 10, 64)
+	session.NumTrades, _ = strconv.ParseInt(xs.NumTrades, 10, 64)
+	session.PriceVolume, _ = strconv.ParseFloat(xs.PriceVolume, 64)
+	session.TradeTime, _ = ParseTime(xs.TradeTime)
+	session.Ticks = ParseTicks(xs.Ticks)
+
+	return session
+}
+
+func parseBook(ba []byte) (Message, error) {
+	var b xmlBook
+	err := xml.Unmarshal(ba, &b)
+	if err != nil {
+		return nil, err
+	}
+
+	m := MessageBook{}
+	m.Symbol = b.Symbol
+	m.BaseCode = b.BaseCode
+
+	count, _ := strconv.Atoi(b.BidCount)
+	m.Bids, err = parseBookLevels(b.BidPrices, b.BidSizes, count, b.BaseCode)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bids for %s. %v", b.Symbol, err)
+	}
+
+	count, _ = strconv.Atoi(b.AskCount)
+	m.Asks, err = parseBookLevels(b.AskPrices, b.AskSizes, count, b.BaseCode)
+	if err != nil {
+		return nil, fmt.Errorf("invalid asks for %s. %v", b.Symbol, err)
+	}
+
+	return m, nil
+}
+
+func parseBookLevels(prices string, sizes string, count int, baseCode string) ([]BookLevel, error) {
+	if count <= 0 || prices == "" {
+		return nil, nil
+	}
+
+	pa := strings.Split(prices, ",")
+	sa := strings.Split(sizes, ",")
+	if len(pa) < count || len(sa) < count {
+		return nil, fmt.Errorf("expected %d levels, got %d prices and %d sizes", count, len(pa), len(sa))
+	}
+
+	levels := make([]BookLevel, count)
+	for i := 0; i < count; i++ {
+		price, err := ParseFloat(pa[i], baseCode)
+		if err != nil {
+			return nil, err
+		}
+
+		size, err := strconv.ParseInt(sa[i], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		levels[i] = BookLevel{Price: price, Size: size}
+	}
+
+	return levels, nil
+}
+
+func parseCumulativeVolume(ba []byte) (Message, error) {
+	var cv xmlCumulativeVolume
+	err := xml.Unmarshal(ba, &cv)
+	if err != nil {
+		return nil, err
+	}
+
+	m := MessageCumulativeVolume{}
+	m.Symbol = cv.Symbol
+	m.BaseCode = cv.BaseCode
+	m.TickIncrement, _ = strconv.Atoi(cv.TickIncrement)
+
+	// data is a list of "price,volume" pairs separated by ':'
+	if cv.Data != "" {
+		for _, pair := range strings.Split(cv.Data, ":") {
+			i := strings.IndexByte(pair, ',')
+			if i == -1 {
+				return nil, fmt.Errorf("invalid volume level \"%s\" for %s", pair, cv.Symbol)
 			}
 
-			var q XMLQuote
-			err := xml.Unmarshal(ba[1:], &q)
+			price, err := ParseFloat(pair[:i], cv.BaseCode)
 			if err != nil {
 				return nil, err
 			}
 
-			m := MessageRefresh{}
-			m.Symbol = q.Symbol
-			m.Name = q.Name
-			m.Exchange = q.Exchange
-			m.DDFExchange = q.DDFExchange
-			m.BaseCode = q.BaseCode
-			m.TickIncrement, err = strconv.Atoi(q.TickIncrement)
-			m.PointValue, err = strconv.ParseFloat(q.PointValue, 32)
-			m.LastUpdate, err = time.Parse("20060102150405", q.LastUpdate)
-
-			m.Bid, err = ParseFloat(q.Bid, q.BaseCode)
-			m.BidSize, err = strconv.ParseInt(q.BidSize, 10, 64)
-			m.Ask, err = ParseFloat(q.Ask, q.BaseCode)
-			m.AskSize, err = strconv.ParseInt(q.AskSize, 10, 64)
-
-			for _, session := range q.Sessions {
-				var ptr *struct {
-					Day          string
-					Session      string
-					Timestamp    time.Time
-					Open         float64
-					High         float64
-					Low          float64
-					Last         float64
-					Previous     float64
-					Settlement   float64
-					TradeSize    int64
-					Volume       int64
-					OpenInterest int64
-					NumTrades    int64
-					PriceVolume  float64
-					TradeTime    time.Time
-					Ticks        string
-				}
-
-				switch session.ID {
-				case "combined":
-					ptr = &m.CurrentSession
-				case "previous":
-					ptr = &m.PreviousSession
-				default:
-					continue
-				}
-
-				ptr.Day = session.Day
-				ptr.Session = session.Session
-				ptr.Timestamp, err = time.Parse("20060102150405", session.Timestamp)
-				ptr.Open, err = ParseFloat(session.Open, q.BaseCode)
-				ptr.High, err = ParseFloat(session.High, q.BaseCode)
-				ptr.Low, err = ParseFloat(session.Low, q.BaseCode)
-				ptr.Last, err = ParseFloat(session.Last, q.BaseCode)
-				ptr.Previous, err = ParseFloat(session.Previous, q.BaseCode)
-				ptr.Settlement, err = ParseFloat(session.Settlement, q.BaseCode)
-				ptr.TradeSize, err = strconv.ParseInt(session.TradeSize, 10, 64)
-				ptr.Volume, err = strconv.ParseInt(session.Volume, 10, 64)
-				ptr.OpenInterest, err = strconv.ParseInt(session.OpenInterest, 10, 64)
-				ptr.NumTrades, err = strconv.ParseInt(session.NumTrades, 10, 64)
-				ptr.PriceVolume, err = strconv.ParseFloat(session.OpenInterest, 64)
-				ptr.TradeTime, err = time.Parse("20060102150405", session.TradeTime)
-				ptr.Ticks = session.Ticks
+			volume, err := strconv.ParseInt(pair[i+1:], 10, 64)
+			if err != nil {
+				return nil, err
 			}
 
-			return m, nil
-
-		} else {
-			return nil, fmt.Errorf("unsupported refresh message")
+			m.Levels = append(m.Levels, VolumeLevel{Price: price, Volume: volume})
 		}
-	default:
-		return nil, fmt.Errorf("malformed message: %d \"%s\"", ba[0], string(ba))
 	}
 
-	return nil, nil
+	return m, nil
 }
@@ -0,0 +1,273 @@
+// Go ddfplus API
+//
+// Copyright 2019 Barchart.com, Inc. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the GNU license
+// available at https://github.com/barchart/go-ddfplus-api/blob/master/LICENSE.
+package ddf
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// goldenCase is a DDF message and its expected decoding. Input uses \xNN
+// escapes for control and binary bytes. Expectations are written by hand
+// from the DDF wire format, with Note showing how they follow from the
+// input, rather than generated from the parser.
+type goldenCase struct {
+	Name    string          `json:"name"`
+	Note    string          `json:"note,omitempty"`
+	Input   string          `json:"input"`
+	Type    string          `json:"type,omitempty"`
+	Error   string          `json:"error,omitempty"`
+	Message json.RawMessage `json:"message,omitempty"`
+}
+
+func unescape(s string) ([]byte, error) {
+	var b []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b = append(b, s[i])
+			continue
+		}
+
+		if i+1 < len(s) && s[i+1] == '\\' {
+			b = append(b, '\\')
+			i++
+			continue
+		}
+
+		if i+3 >= len(s) || s[i+1] != 'x' {
+			return nil, fmt.Errorf("invalid escape at %d", i)
+		}
+
+		n, err := strconv.ParseUint(s[i+2:i+4], 16, 8)
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, byte(n))
+		i += 3
+	}
+
+	return b, nil
+}
+
+func loadGolden(tb testing.TB) []goldenCase {
+	data, err := os.ReadFile("testdata/golden.json")
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	var cases []goldenCase
+	err = json.Unmarshal(data, &cases)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return cases
+}
+
+func decodeGolden(ba []byte) (typ string, errs string, msg json.RawMessage) {
+	m, err := Parse(ba)
+	if err != nil {
+		return "", err.Error(), nil
+	}
+
+	// The receive time of a raw message is the time it was parsed
+	if raw, ok := m.(MessageRaw); ok {
+		raw.Received = time.Time{}
+		m = raw
+	}
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		return "", err.Error(), nil
+	}
+
+	return fmt.Sprintf("%T", m), "", data
+}
+
+func TestGolden(t *testing.T) {
+	cases := loadGolden(t)
+
+	for _, c := range cases {
+		ba, err := unescape(c.Input)
+		if err != nil {
+			t.Fatalf("%s: %v", c.Name, err)
+		}
+
+		typ, errs, msg := decodeGolden(ba)
+
+		if typ != c.Type || errs != c.Error {
+			t.Errorf("%s: got %s %q, expected %s %q", c.Name, typ, errs, c.Type, c.Error)
+			continue
+		}
+
+		var want bytes.Buffer
+		if len(c.Message) > 0 {
+			json.Compact(&want, c.Message)
+		}
+		if !bytes.Equal(msg, want.Bytes()) {
+			t.Errorf("%s:\n got %s\nwant %s", c.Name, msg, want.Bytes())
+		}
+	}
+
+}
+
+func TestPeekTypeGolden(t *testing.T) {
+	for _, c := range loadGolden(t) {
+		ba, err := unescape(c.Input)
+		if err != nil {
+			t.Fatalf("%s: %v", c.Name, err)
+		}
+
+		m, err := Parse(ba)
+		if err != nil {
+			continue
+		}
+
+		if typ := PeekType(ba); typ != m.Type() {
+			t.Errorf("%s: peeked %v, parsed %v", c.Name, typ, m.Type())
+		}
+	}
+}
+
+// ESZ9 trade of 3065.00 x 2 and bid/ask of 3065.00 x 12 / 3065.25 x 8, at
+// 2019-11-01 09:30:15.250.
+var (
+	benchTrade  = []byte("\x012ESZ9,7\x02AM10306500,2,1G\x03\x14\x53\x4B\x41\x49\x5E\x4F\xFA\x00")
+	benchBidAsk = []byte("\x012ESZ9,8\x02AM10306500,12,306525,8,1G\x03\x14\x53\x4B\x41\x49\x5E\x4F\xFA\x00")
+)
+
+func BenchmarkParseTrade(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		Parse(benchTrade)
+	}
+}
+
+func BenchmarkParseBidAsk(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		Parse(benchBidAsk)
+	}
+}
+
+func BenchmarkParserParse(b *testing.B) {
+	p := NewParser()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		p.Parse(benchTrade)
+	}
+}
+
+func BenchmarkParserParseTrade(b *testing.B) {
+	p := NewParser()
+	var m MessageTrade
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		p.ParseTrade(benchTrade, &m)
+	}
+}
+
+func BenchmarkParserParseBidAsk(b *testing.B) {
+	p := NewParser()
+	var m MessageBidAsk
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		p.ParseBidAsk(benchBidAsk, &m)
+	}
+}
+
+func TestParserParseTrade(t *testing.T) {
+	p := NewParser()
+
+	var m MessageTrade
+	err := p.ParseTrade(benchTrade, &m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Symbol != "ESZ9" || m.Trade != 3065.0 || m.TradeSize != 2 {
+		t.Errorf("unexpected trade %+v", m)
+	}
+
+	if m.Timestamp.Nanosecond() != 250000000 {
+		t.Errorf("expected 250ms, got %v", m.Timestamp)
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		p.ParseTrade(benchTrade, &m)
+	})
+	if allocs != 0 {
+		t.Errorf("expected no allocations, got %v", allocs)
+	}
+}
+
+func TestParseTimestamp(t *testing.T) {
+	loc := Location()
+	for _, c := range []struct {
+		input []byte
+		want  time.Time
+		err   bool
+	}{
+		{[]byte("\x03\x14\x53\x4b\x41\x49\x5e\x4f\xfa\x00"), time.Date(2019, 11, 1, 9, 30, 15, 250*int(time.Millisecond), loc), false},
+		{[]byte("\x03\x14\x53\x4b\x41\x49\x5e\x4f\xe7\x03"), time.Date(2019, 11, 1, 9, 30, 15, 999*int(time.Millisecond), loc), false},
+		{[]byte("\x03\x14\x53\x4b\x41\x49\x5e\x4f"), time.Date(2019, 11, 1, 9, 30, 15, 0, loc), false},
+		{[]byte("\x03\x13\x53\x4b\x41\x49\x5e\x4f"), time.Date(1919, 11, 1, 9, 30, 15, 0, loc), false},
+		{[]byte("\x03\x14\x53\x4b\x41\x49\x5e"), time.Time{}, true},
+	} {
+		ts, err := ParseTimestamp(c.input, 0)
+		if (err != nil) != c.err || !ts.Equal(c.want) {
+			t.Errorf("%q parsed as %v, %v, expected %v", c.input, ts, err, c.want)
+		}
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	ts, err := ParseTime("20191101093015")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ts.Location() != Location() || !ts.Equal(time.Date(2019, 11, 1, 14, 30, 15, 0, time.UTC)) {
+		t.Errorf("unexpected time %v", ts)
+	}
+
+	_, err = ParseTime("2019110109301")
+	if err == nil {
+		t.Error("expected an error for a short time")
+	}
+
+	defer SetLocation(nil)
+	SetLocation(time.UTC)
+	ts, _ = ParseTime("20191101093015")
+	if !ts.Equal(time.Date(2019, 11, 1, 9, 30, 15, 0, time.UTC)) {
+		t.Errorf("unexpected UTC time %v", ts)
+	}
+}
+
+func TestSetLocationConcurrent(t *testing.T) {
+	defer SetLocation(nil)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			SetLocation(time.UTC)
+			SetLocation(nil)
+		}
+	}()
+
+	for i := 0; i < 100; i++ {
+		ParseTime("20191101093015")
+		ParseTimestamp([]byte("\x03\x14\x53\x4b\x41\x49\x5e\x4f"), 0)
+	}
+	<-done
+}
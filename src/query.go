@@ -0,0 +1,154 @@
+// Go ddfplus API
+//
+// Copyright 2019 Barchart.com, Inc. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the GNU license
+// available at https://github.com/barchart/go-ddfplus-api/blob/master/LICENSE.
+package ddf
+
+import "sort"
+
+// SortKey orders the results of a Query.
+type SortKey int
+
+const (
+	SortSymbol SortKey = iota
+	SortPercentChange
+	SortNetChange
+	SortVolume
+	SortLast
+)
+
+// Query selects and orders quotes. Empty fields match every quote, so the
+// zero Query returns the whole DB sorted by symbol.
+type Query struct {
+	Exchange    string     // Quote.Info.Exchange, such as "CME"
+	DDFExchange string     // Quote.Info.DDFExchange, such as "M"
+	Root        string     // the root of the symbol from ParseSymbol, such as "ES"
+	Type        SymbolType // Future or FutureOption; Unknown matches any type
+	Where       func(q *Quote) bool
+
+	SortBy     SortKey
+	Descending bool
+	Limit      int // zero is no limit
+}
+
+func (query *Query) match(q *Quote, s Symbol) bool {
+	if query.Exchange != "" && q.Info.Exchange != query.Exchange {
+		return false
+	}
+	if query.DDFExchange != "" && q.Info.DDFExchange != query.DDFExchange {
+		return false
+	}
+	if query.Root != "" && s.Root != query.Root {
+		return false
+	}
+	if query.Type != Unknown && s.Type != query.Type {
+		return false
+	}
+
+	return query.Where == nil || query.Where(q)
+}
+
+func sortValue(q *Quote, key SortKey) float64 {
+	switch key {
+	case SortPercentChange:
+		return q.Derived.PercentChange
+	case SortNetChange:
+		return q.Derived.NetChange
+	case SortVolume:
+		return float64(q.Data.CurrentSession.Volume)
+	case SortLast:
+		return q.Data.CurrentSession.Last
+	}
+
+	return 0
+}
+
+// sortQuotes sorts quotes by key, breaking ties by symbol so that results
+// are stable between calls.
+func sortQuotes(quotes []*Quote, key SortKey, descending bool) {
+	sort.Slice(quotes, func(i, j int) bool {
+		a, b := quotes[i], quotes[j]
+		if key != SortSymbol {
+			va, vb := sortValue(a, key), sortValue(b, key)
+			if va != vb {
+				return (va < vb) != descending
+			}
+		}
+
+		return (a.Symbol < b.Symbol) != (descending && key == SortSymbol)
+	})
+}
+
+// snapshotQuotes copies the quotes matching query under a single read lock,
+// so they all come from the same point of the feed.
+func (db *DB) snapshotQuotes(query *Query) []*Quote {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var quotes []*Quote
+	for s, q := range db.data {
+		if query != nil && !query.match(q, db.symbols[s]) {
+			continue
+		}
+
+		c := *q
+		quotes = append(quotes, &c)
+	}
+
+	return quotes
+}
+
+// Range calls f with a copy of each quote, sorted by symbol, until f returns
+// false. The copies are taken at a single point of the feed, and f is
+// called without the DB lock, so it may use the DB.
+func (db *DB) Range(f func(q *Quote) bool) {
+	quotes := db.snapshotQuotes(nil)
+	sortQuotes(quotes, SortSymbol, false)
+
+	for _, q := range quotes {
+		if !f(q) {
+			return
+		}
+	}
+}
+
+// Query returns copies of the quotes matching query, in its order. As with
+// Range, the quotes are consistent with each other. Where is called with the
+// DB read lock held, so it must not call the DB.
+func (db *DB) Query(query Query) []*Quote {
+	quotes := db.snapshotQuotes(&query)
+	sortQuotes(quotes, query.SortBy, query.Descending)
+
+	if query.Limit > 0 && len(quotes) > query.Limit {
+		quotes = quotes[:query.Limit]
+	}
+
+	return quotes
+}
+
+// TopMovers returns up to n quotes matching query with the largest percent
+// gains, and up to n with the largest percent losses, largest first. The
+// query's order and limit are ignored.
+func (db *DB) TopMovers(query Query, n int) (gainers []*Quote, losers []*Quote) {
+	quotes := db.snapshotQuotes(&query)
+	sortQuotes(quotes, SortPercentChange, true)
+
+	for _, q := range quotes {
+		if len(gainers) == n || q.Derived.PercentChange <= 0 {
+			break
+		}
+		gainers = append(gainers, q)
+	}
+
+	for i := len(quotes) - 1; i >= 0; i-- {
+		q := quotes[i]
+		if len(losers) == n || q.Derived.PercentChange >= 0 {
+			break
+		}
+		losers = append(losers, q)
+	}
+
+	return gainers, losers
+}
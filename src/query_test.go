@@ -0,0 +1,92 @@
+// Go ddfplus API
+//
+// Copyright 2019 Barchart.com, Inc. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the GNU license
+// available at https://github.com/barchart/go-ddfplus-api/blob/master/LICENSE.
+package ddf
+
+import (
+	"strings"
+	"testing"
+)
+
+func queryDB() *DB {
+	db := InitDB()
+	for _, q := range []struct {
+		symbol   string
+		exchange string
+		last     float64
+		previous float64
+		volume   int64
+	}{
+		{"ESZ9", "M", 3030, 3000, 1000},
+		{"ESH0", "M", 2970, 3000, 10},
+		{"ES3000C", "M", 25, 20, 5},
+		{"CLZ9", "N", 57, 56, 500},
+		{"GCZ9", "X", 1500, 1500, 300},
+	} {
+		db.Process(MessageRefresh{
+			Symbol:      q.symbol,
+			BaseCode:    "A",
+			DDFExchange: q.exchange,
+			CurrentSession: RefreshSession{
+				Last:     q.last,
+				Previous: q.previous,
+				Volume:   q.volume,
+			},
+		})
+	}
+
+	return db
+}
+
+func symbolsOf(quotes []*Quote) string {
+	var symbols []string
+	for _, q := range quotes {
+		symbols = append(symbols, q.Symbol)
+	}
+
+	return strings.Join(symbols, ",")
+}
+
+func TestQuery(t *testing.T) {
+	db := queryDB()
+
+	var seen []*Quote
+	db.Range(func(q *Quote) bool {
+		seen = append(seen, q)
+		return len(seen) < 3
+	})
+	if s := symbolsOf(seen); s != "CLZ9,ES3000C,ESH0" {
+		t.Errorf("unexpected range %s", s)
+	}
+
+	for _, c := range []struct {
+		query Query
+		want  string
+	}{
+		{Query{}, "CLZ9,ES3000C,ESH0,ESZ9,GCZ9"},
+		{Query{DDFExchange: "M"}, "ES3000C,ESH0,ESZ9"},
+		{Query{Root: "ES", Type: Future}, "ESH0,ESZ9"},
+		{Query{Type: FutureOption}, "ES3000C"},
+		{Query{SortBy: SortVolume, Descending: true, Limit: 2}, "ESZ9,CLZ9"},
+		{Query{SortBy: SortPercentChange, Type: Future}, "ESH0,GCZ9,ESZ9,CLZ9"},
+		{Query{Where: func(q *Quote) bool { return q.Data.CurrentSession.Volume < 100 }}, "ES3000C,ESH0"},
+	} {
+		if s := symbolsOf(db.Query(c.query)); s != c.want {
+			t.Errorf("%+v returned %s, expected %s", c.query, s, c.want)
+		}
+	}
+
+	gainers, losers := db.TopMovers(Query{Type: Future}, 1)
+	if symbolsOf(gainers) != "CLZ9" || symbolsOf(losers) != "ESH0" {
+		t.Errorf("unexpected movers %s and %s", symbolsOf(gainers), symbolsOf(losers))
+	}
+
+	// Results are copies
+	db.Query(Query{Root: "ES"})[0].Data.CurrentSession.Last = 1
+	if db.GetQuote("ES3000C").Data.CurrentSession.Last != 25 {
+		t.Error("query returned a quote of the DB")
+	}
+}
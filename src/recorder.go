@@ -0,0 +1,278 @@
+// Go ddfplus API
+//
+// Copyright 2019 Barchart.com, Inc. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the GNU license
+// available at https://github.com/barchart/go-ddfplus-api/blob/master/LICENSE.
+package ddf
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Recordings start with this magic, followed by frames of an 8 byte receive
+// time in Unix nanoseconds, a 4 byte length and the frame itself, all big
+// endian.
+const recordMagic = "DDFREC\x00\x01"
+
+const maxFrameSize = 16 << 20
+
+type Compression int
+
+const (
+	CompressNone Compression = iota
+	CompressGzip
+)
+
+type RecorderOptions struct {
+	Dir    string
+	Prefix string
+
+	// MaxSize rotates to a new file once this many bytes of frames have been
+	// written. Zero disables size rotation.
+	MaxSize int64
+
+	// RotateDaily rotates to a new file when the trading day changes. The
+	// trading day is the date of the receive time in Location() plus
+	// DayOffset, so an offset of 7h rolls a 17:00 session open over to the
+	// next day.
+	RotateDaily bool
+	DayOffset   time.Duration
+
+	Compression Compression
+
+	// Compressor, if set, overrides Compression, for formats such as zstd
+	// that are not in the standard library. Extension is appended to the
+	// file name.
+	Compressor func(w io.Writer) (io.WriteCloser, error)
+	Extension  string
+}
+
+type Frame struct {
+	Time time.Time
+	Data []byte
+}
+
+// Recorder writes the raw frames received by a Connection to disk.
+type Recorder struct {
+	mu      sync.Mutex
+	options RecorderOptions
+	file    *os.File
+	zw      io.WriteCloser
+	bw      *bufio.Writer
+	size    int64
+	day     time.Time
+	header  [12]byte
+}
+
+func NewRecorder(options RecorderOptions) (*Recorder, error) {
+	if options.Prefix == "" {
+		options.Prefix = "ddf"
+	}
+
+	if options.Dir != "" {
+		err := os.MkdirAll(options.Dir, 0755)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &Recorder{options: options}, nil
+}
+
+func (r *Recorder) tradingDay(t time.Time) time.Time {
+	t = t.In(Location()).Add(r.options.DayOffset)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, Location())
+}
+
+func (r *Recorder) open(t time.Time) error {
+	ext := ".ddf"
+	switch {
+	case r.options.Compressor != nil:
+		ext += r.options.Extension
+	case r.options.Compression == CompressGzip:
+		ext += ".gz"
+	}
+
+	name := fmt.Sprintf("%s-%s%s", r.options.Prefix, t.In(Location()).Format("20060102-150405.000000000"), ext)
+	f, err := os.OpenFile(filepath.Join(r.options.Dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = f
+	r.zw = nil
+	switch {
+	case r.options.Compressor != nil:
+		r.zw, err = r.options.Compressor(f)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		w = r.zw
+	case r.options.Compression == CompressGzip:
+		r.zw = gzip.NewWriter(f)
+		w = r.zw
+	}
+
+	r.file = f
+	r.bw = bufio.NewWriterSize(w, 64<<10)
+	r.size = 0
+	r.day = r.tradingDay(t)
+
+	_, err = r.bw.WriteString(recordMagic)
+	return err
+}
+
+func (r *Recorder) closeFile() error {
+	if r.file == nil {
+		return nil
+	}
+
+	err := r.bw.Flush()
+	if r.zw != nil {
+		if zerr := r.zw.Close(); err == nil {
+			err = zerr
+		}
+	}
+
+	if ferr := r.file.Close(); err == nil {
+		err = ferr
+	}
+
+	r.file = nil
+	return err
+}
+
+// Record appends a frame received at t, rotating the file first if needed.
+func (r *Recorder) Record(t time.Time, frame []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file != nil {
+		rotate := r.options.MaxSize > 0 && r.size >= r.options.MaxSize
+		if r.options.RotateDaily && !r.tradingDay(t).Equal(r.day) {
+			rotate = true
+		}
+
+		if rotate {
+			err := r.closeFile()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if r.file == nil {
+		err := r.open(t)
+		if err != nil {
+			return err
+		}
+	}
+
+	binary.BigEndian.PutUint64(r.header[0:8], uint64(t.UnixNano()))
+	binary.BigEndian.PutUint32(r.header[8:12], uint32(len(frame)))
+	_, err := r.bw.Write(r.header[:])
+	if err != nil {
+		return err
+	}
+
+	_, err = r.bw.Write(frame)
+	r.size += int64(len(r.header) + len(frame))
+	return err
+}
+
+// Flush writes buffered frames through to the file.
+func (r *Recorder) Flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return nil
+	}
+
+	err := r.bw.Flush()
+	if err != nil {
+		return err
+	}
+
+	if f, ok := r.zw.(interface{ Flush() error }); ok {
+		return f.Flush()
+	}
+
+	return nil
+}
+
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.closeFile()
+}
+
+// FrameReader reads frames from a recording, which may be gzip compressed.
+type FrameReader struct {
+	r      *bufio.Reader
+	header [12]byte
+}
+
+func NewFrameReader(r io.Reader) (*FrameReader, error) {
+	br := bufio.NewReader(r)
+
+	magic, err := br.Peek(2)
+	if err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		br = bufio.NewReader(zr)
+	}
+
+	magic = make([]byte, len(recordMagic))
+	_, err = io.ReadFull(br, magic)
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(magic, []byte(recordMagic)) {
+		return nil, fmt.Errorf("not a ddf recording")
+	}
+
+	return &FrameReader{r: br}, nil
+}
+
+// Next returns the next frame, or io.EOF at the end of the recording.
+func (fr *FrameReader) Next() (Frame, error) {
+	var f Frame
+
+	_, err := io.ReadFull(fr.r, fr.header[:])
+	if err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = fmt.Errorf("truncated frame header")
+		}
+		return f, err
+	}
+
+	n := binary.BigEndian.Uint32(fr.header[8:12])
+	if n > maxFrameSize {
+		return f, fmt.Errorf("frame size %d too large", n)
+	}
+
+	f.Time = time.Unix(0, int64(binary.BigEndian.Uint64(fr.header[0:8]))).In(Location())
+	f.Data = make([]byte, n)
+	_, err = io.ReadFull(fr.r, f.Data)
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		err = fmt.Errorf("truncated frame")
+	}
+
+	return f, err
+}
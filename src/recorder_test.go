@@ -0,0 +1,97 @@
+// Go ddfplus API
+//
+// Copyright 2019 Barchart.com, Inc. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the GNU license
+// available at https://github.com/barchart/go-ddfplus-api/blob/master/LICENSE.
+package ddf
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+func readRecording(t *testing.T, name string) []Frame {
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	fr, err := NewFrameReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var frames []Frame
+	for {
+		frame, err := fr.Next()
+		if err == io.EOF {
+			return frames
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		frames = append(frames, frame)
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	for _, compression := range []Compression{CompressNone, CompressGzip} {
+		dir := t.TempDir()
+
+		rec, err := NewRecorder(RecorderOptions{
+			Dir:         dir,
+			MaxSize:     100,
+			RotateDaily: true,
+			Compression: compression,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		start := time.Date(2019, 11, 1, 23, 59, 59, 0, Location())
+		for i := 0; i < 4; i++ {
+			err = rec.Record(start.Add(time.Duration(i)*time.Millisecond), benchTrade)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// A new trading day starts a new file
+		err = rec.Record(start.Add(time.Second), benchBidAsk)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = rec.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		names, _ := filepath.Glob(filepath.Join(dir, "*"))
+		sort.Strings(names)
+
+		var frames []Frame
+		for _, name := range names {
+			frames = append(frames, readRecording(t, name)...)
+		}
+
+		// 41 byte frames, so size rotation after every third frame
+		if len(names) != 3 {
+			t.Errorf("expected 3 files, got %v", names)
+		}
+
+		if len(frames) != 5 {
+			t.Fatalf("expected 5 frames, got %d", len(frames))
+		}
+
+		if !frames[1].Time.Equal(start.Add(time.Millisecond)) || string(frames[4].Data) != string(benchBidAsk) {
+			t.Errorf("unexpected frames %v", frames)
+		}
+	}
+}
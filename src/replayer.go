@@ -0,0 +1,150 @@
+// Go ddfplus API
+//
+// Copyright 2019 Barchart.com, Inc. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the GNU license
+// available at https://github.com/barchart/go-ddfplus-api/blob/master/LICENSE.
+package ddf
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// Replayer plays a recording made by a Recorder through the parser and
+// subscriber fan-out of a Connection, so anything registered on the
+// connection, such as a DB, runs unchanged against historical data.
+type Replayer struct {
+	conn    *Connection
+	frames  *FrameReader
+	speed   float64
+	seek    time.Time
+	symbols map[string]bool
+	stop    chan struct{}
+	once    sync.Once
+}
+
+func NewReplayer(r io.Reader) (*Replayer, error) {
+	frames, err := NewFrameReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Replayer{
+		conn:   newConnection(nil),
+		frames: frames,
+		speed:  1.0,
+		stop:   make(chan struct{}),
+	}, nil
+}
+
+// Connection returns the connection subscribers register with.
+func (r *Replayer) Connection() *Connection {
+	return r.conn
+}
+
+// SetSpeed sets the pacing as a multiple of real time. A speed of 0 plays
+// the recording as fast as possible.
+func (r *Replayer) SetSpeed(speed float64) {
+	r.speed = speed
+}
+
+// Seek starts playback at t. Frames received before t carrying state,
+// such as refreshes, depth and timestamps, are still dispatched, without
+// pacing, so subscribers start from the state of the feed at t. Trades and
+// other updates before t are skipped.
+func (r *Replayer) Seek(t time.Time) {
+	r.seek = t
+}
+
+// Filter only dispatches messages for the given symbols. Timestamps are
+// always dispatched.
+func (r *Replayer) Filter(symbols []string) {
+	r.symbols = make(map[string]bool)
+	for _, s := range symbols {
+		r.symbols[s] = true
+	}
+}
+
+func (r *Replayer) accept(m Message) bool {
+	if r.symbols == nil || m.Type() == Timestamp {
+		return true
+	}
+
+	return r.symbols[MessageSymbol(m)]
+}
+
+// acceptState accepts the messages before the seek time that carry state.
+func (r *Replayer) acceptState(m Message) bool {
+	switch m.Type() {
+	case Refresh, Book, CumulativeVolume, Timestamp:
+		return r.accept(m)
+	}
+
+	return false
+}
+
+// Stop ends a Run in progress. It may be called more than once.
+func (r *Replayer) Stop() {
+	r.once.Do(func() {
+		close(r.stop)
+	})
+}
+
+// Run plays the recording until it ends or Stop is called.
+func (r *Replayer) Run() error {
+	var (
+		first time.Time
+		start time.Time
+		timer *time.Timer
+	)
+
+	for {
+		select {
+		case <-r.stop:
+			return nil
+		default:
+		}
+
+		f, err := r.frames.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		accept := r.accept
+		if f.Time.Before(r.seek) {
+			accept = r.acceptState
+		} else if r.speed > 0 {
+			if first.IsZero() {
+				first = f.Time
+				start = time.Now()
+			}
+
+			due := start.Add(time.Duration(float64(f.Time.Sub(first)) / r.speed))
+			if wait := time.Until(due); wait > 0 {
+				if timer == nil {
+					timer = time.NewTimer(wait)
+				} else {
+					timer.Reset(wait)
+				}
+
+				select {
+				case <-timer.C:
+				case <-r.stop:
+					timer.Stop()
+					return nil
+				}
+			}
+		}
+
+		perr := r.conn.handle(f.Data, accept)
+		if perr != nil {
+			perr.Received = f.Time
+			r.conn.reportParseError(perr)
+		}
+	}
+}
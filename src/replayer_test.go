@@ -0,0 +1,61 @@
+// Go ddfplus API
+//
+// Copyright 2019 Barchart.com, Inc. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the GNU license
+// available at https://github.com/barchart/go-ddfplus-api/blob/master/LICENSE.
+package ddf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestReplayer(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString(recordMagic)
+
+	refresh := []byte(`%<QUOTE symbol="ESZ9" basecode="A" ddfexchange="M"><SESSION day="1" session="G" id="combined" last="306400"/></QUOTE>`)
+	other := []byte("\x012CLZ9,7\x02AM105670,3,1G\x03\x14\x53\x4b\x41\x49\x5e\x4f\xfa\x00")
+	start := time.Date(2019, 11, 1, 9, 30, 0, 0, Location())
+	for i, frame := range [][]byte{refresh, benchTrade, other, benchBidAsk, benchTrade} {
+		var header [12]byte
+		binary.BigEndian.PutUint64(header[0:8], uint64(start.Add(time.Duration(i)*time.Second).UnixNano()))
+		binary.BigEndian.PutUint32(header[8:12], uint32(len(frame)))
+		buf.Write(header[:])
+		buf.Write(frame)
+	}
+
+	r, err := NewReplayer(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.SetSpeed(0)
+	r.Seek(start.Add(2 * time.Second))
+	r.Filter([]string{"ESZ9"})
+
+	ch := make(chan Message, 10)
+	r.Connection().RegisterMarketUpdateAll(ch)
+
+	err = r.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	close(ch)
+
+	var types []MessageType
+	for m := range ch {
+		types = append(types, m.Type())
+	}
+
+	// The refresh and the first trade are before the seek time, but only the
+	// trade is skipped. CLZ9 is filtered out.
+	if len(types) != 3 || types[0] != Refresh || types[1] != BidAsk || types[2] != Trade {
+		t.Errorf("unexpected messages %v", types)
+	}
+
+	r.Stop()
+	r.Stop()
+}
@@ -0,0 +1,366 @@
+// Go ddfplus API
+//
+// Copyright 2019 Barchart.com, Inc. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the GNU license
+// available at https://github.com/barchart/go-ddfplus-api/blob/master/LICENSE.
+package ddf
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+)
+
+// Frames queued for a client beyond this are treated as a stalled client,
+// which is disconnected rather than allowed to hold up everyone else.
+const serverClientQueue = 4096
+
+// Server is the server side of the jerq protocol, so that a Connection can
+// be pointed at a simulator or proxy instead of ddfplus.
+type Server struct {
+	// Authenticate checks a LOGIN. A nil Authenticate accepts everyone.
+	Authenticate func(username string, password string) bool
+
+	// OnSubscribe is called after a client's GO request with the symbols it
+	// added, and OnUnsubscribe after a STOP request or disconnect with the
+	// symbols it removed.
+	OnSubscribe   func(c *ServerClient, symbols []string)
+	OnUnsubscribe func(c *ServerClient, symbols []string)
+
+	mu       sync.RWMutex
+	clients  map[*ServerClient]bool
+	listener net.Listener
+}
+
+type ServerClient struct {
+	conn     net.Conn
+	username string
+	mu       sync.RWMutex
+	symbols  map[string]bool
+	out      chan []byte
+	done     chan struct{}
+	once     sync.Once
+}
+
+func NewServer() *Server {
+	return &Server{
+		clients: make(map[*ServerClient]bool),
+	}
+}
+
+func (s *Server) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return s.Serve(ln)
+}
+
+func (s *Server) Serve(ln net.Listener) error {
+	s.mu.Lock()
+	s.listener = ln
+	s.mu.Unlock()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+
+		go s.serveClient(conn)
+	}
+}
+
+// Close stops accepting clients and disconnects the connected ones.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	ln := s.listener
+	clients := make([]*ServerClient, 0, len(s.clients))
+	for c := range s.clients {
+		clients = append(clients, c)
+	}
+	s.mu.Unlock()
+
+	for _, c := range clients {
+		c.Close()
+	}
+
+	if ln != nil {
+		return ln.Close()
+	}
+
+	return nil
+}
+
+// Publish sends m to every client subscribed to its symbol. Messages without
+// a symbol, such as timestamps, go to every client.
+func (s *Server) Publish(m Message) error {
+	frame, err := AppendMessage(nil, m)
+	if err != nil {
+		return err
+	}
+
+	s.PublishFrame(MessageSymbol(m), frame)
+	return nil
+}
+
+// PublishFrame sends an already encoded frame to every client subscribed to
+// symbol, or to every client if symbol is empty.
+func (s *Server) PublishFrame(symbol string, frame []byte) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for c := range s.clients {
+		if symbol == "" || c.Subscribed(symbol) {
+			c.SendFrame(frame)
+		}
+	}
+}
+
+// Clients returns the connected, logged in clients.
+func (s *Server) Clients() []*ServerClient {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	clients := make([]*ServerClient, 0, len(s.clients))
+	for c := range s.clients {
+		clients = append(clients, c)
+	}
+
+	return clients
+}
+
+func (s *Server) serveClient(conn net.Conn) {
+	c := &ServerClient{
+		conn:    conn,
+		symbols: make(map[string]bool),
+		out:     make(chan []byte, serverClientQueue),
+		done:    make(chan struct{}),
+	}
+	defer c.Close()
+
+	reader := bufio.NewReader(conn)
+	fmt.Fprintf(conn, "+ jerq server ready\r\n")
+
+	// LOGIN username:password
+	line, _, err := reader.ReadLine()
+	if err != nil {
+		return
+	}
+
+	cmd, args := splitCommand(string(line))
+	if cmd != "LOGIN" {
+		fmt.Fprintf(conn, "- Expected LOGIN\r\n")
+		return
+	}
+
+	username, password := args, ""
+	if i := strings.IndexByte(args, ':'); i != -1 {
+		username, password = args[:i], args[i+1:]
+	}
+
+	if s.Authenticate != nil && !s.Authenticate(username, password) {
+		fmt.Fprintf(conn, "- Login failed\r\n")
+		log.Printf("Login failed for \"%s\" from %v", username, conn.RemoteAddr())
+		return
+	}
+	c.username = username
+	fmt.Fprintf(conn, "+ Successful login\r\n")
+
+	s.mu.Lock()
+	s.clients[c] = true
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.clients, c)
+		s.mu.Unlock()
+
+		if symbols := c.Symbols(); len(symbols) > 0 && s.OnUnsubscribe != nil {
+			s.OnUnsubscribe(c, symbols)
+		}
+	}()
+
+	go c.writeLoop()
+
+	for {
+		line, _, err := reader.ReadLine()
+		if err != nil {
+			return
+		}
+
+		cmd, args := splitCommand(string(line))
+		switch cmd {
+		case "VERSION":
+			c.SendFrame([]byte("+ VERSION " + args))
+		case "GO":
+			added := c.subscribe(args)
+			if len(added) > 0 && s.OnSubscribe != nil {
+				s.OnSubscribe(c, added)
+			}
+		case "STOP":
+			removed := c.unsubscribe(args)
+			if len(removed) > 0 && s.OnUnsubscribe != nil {
+				s.OnUnsubscribe(c, removed)
+			}
+		case "EXIT", "QUIT":
+			return
+		case "":
+		default:
+			c.SendFrame([]byte("- Unknown command " + cmd))
+		}
+	}
+}
+
+func splitCommand(line string) (string, string) {
+	line = strings.TrimSpace(line)
+	if i := strings.IndexByte(line, ' '); i != -1 {
+		return strings.ToUpper(line[:i]), strings.TrimSpace(line[i+1:])
+	}
+
+	return strings.ToUpper(line), ""
+}
+
+// parseSymbolList reads "ESZ9=Ss,CLZ9=Ss" or "ESZ9,CLZ9", ignoring the
+// subscription flags.
+func parseSymbolList(args string) []string {
+	var symbols []string
+	for _, s := range strings.Split(args, ",") {
+		if i := strings.IndexByte(s, '='); i != -1 {
+			s = s[:i]
+		}
+
+		s = strings.TrimSpace(s)
+		if s != "" {
+			symbols = append(symbols, s)
+		}
+	}
+
+	return symbols
+}
+
+func (c *ServerClient) subscribe(args string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var added []string
+	for _, s := range parseSymbolList(args) {
+		if !c.symbols[s] {
+			c.symbols[s] = true
+			added = append(added, s)
+		}
+	}
+
+	return added
+}
+
+// unsubscribe removes the listed symbols, or all of them if none are listed.
+func (c *ServerClient) unsubscribe(args string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	symbols := parseSymbolList(args)
+	if len(symbols) == 0 {
+		for s := range c.symbols {
+			symbols = append(symbols, s)
+		}
+	}
+
+	var removed []string
+	for _, s := range symbols {
+		if c.symbols[s] {
+			delete(c.symbols, s)
+			removed = append(removed, s)
+		}
+	}
+
+	return removed
+}
+
+func (c *ServerClient) Username() string {
+	return c.username
+}
+
+func (c *ServerClient) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+func (c *ServerClient) Subscribed(symbol string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.symbols[symbol]
+}
+
+func (c *ServerClient) Symbols() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	symbols := make([]string, 0, len(c.symbols))
+	for s := range c.symbols {
+		symbols = append(symbols, s)
+	}
+
+	return symbols
+}
+
+// Send sends m to this client only, such as a refresh after it subscribes.
+func (c *ServerClient) Send(m Message) error {
+	frame, err := AppendMessage(nil, m)
+	if err != nil {
+		return err
+	}
+
+	c.SendFrame(frame)
+	return nil
+}
+
+// SendFrame queues an encoded frame for the client. A client that has fallen
+// too far behind is disconnected.
+func (c *ServerClient) SendFrame(frame []byte) {
+	select {
+	case <-c.done:
+	case c.out <- frame:
+	default:
+		log.Printf("Client %v is not keeping up, disconnecting.", c.conn.RemoteAddr())
+		c.Close()
+	}
+}
+
+func (c *ServerClient) Close() {
+	c.once.Do(func() {
+		close(c.done)
+		c.conn.Close()
+	})
+}
+
+func (c *ServerClient) writeLoop() {
+	w := bufio.NewWriter(c.conn)
+	for {
+		select {
+		case <-c.done:
+			return
+		case frame := <-c.out:
+			w.Write(frame)
+			w.WriteByte('\n')
+
+			// Batch whatever else is queued into the same write
+			for n := len(c.out); n > 0; n-- {
+				frame = <-c.out
+				w.Write(frame)
+				w.WriteByte('\n')
+			}
+
+			err := w.Flush()
+			if err != nil {
+				c.Close()
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,134 @@
+// Go ddfplus API
+//
+// Copyright 2019 Barchart.com, Inc. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the GNU license
+// available at https://github.com/barchart/go-ddfplus-api/blob/master/LICENSE.
+package ddf
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestServerConnection(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trade, _ := Parse(benchTrade)
+
+	server := NewServer()
+	server.Authenticate = func(username string, password string) bool {
+		return username == "user" && password == "secret"
+	}
+	server.OnSubscribe = func(c *ServerClient, symbols []string) {
+		if len(symbols) != 1 || symbols[0] != "ESZ9" {
+			t.Errorf("unexpected subscription %v", symbols)
+		}
+		c.Send(trade)
+	}
+	go server.Serve(ln)
+	defer server.Close()
+
+	conn := NewConnectionTo(ln.Addr().String(), &Credentials{Username: "user", Password: "secret"})
+	ch := make(chan Message, 1)
+	conn.RegisterMarketUpdate([]string{"ESZ9"}, ch)
+	go conn.Start()
+
+	select {
+	case m := <-ch:
+		if m.(MessageTrade).Symbol != "ESZ9" || !m.(MessageTrade).Timestamp.Equal(trade.(MessageTrade).Timestamp) {
+			t.Errorf("unexpected message %+v", m)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message from server")
+	}
+}
+
+func TestConnectionLiveSubscription(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subscribed := make(chan []string, 4)
+	unsubscribed := make(chan []string, 4)
+
+	server := NewServer()
+	server.OnSubscribe = func(c *ServerClient, symbols []string) {
+		subscribed <- symbols
+	}
+	server.OnUnsubscribe = func(c *ServerClient, symbols []string) {
+		unsubscribed <- symbols
+	}
+	go server.Serve(ln)
+	defer server.Close()
+
+	expect := func(ch chan []string, symbol string) {
+		t.Helper()
+		select {
+		case symbols := <-ch:
+			if len(symbols) != 1 || symbols[0] != symbol {
+				t.Fatalf("expected %s, got %v", symbol, symbols)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no request for %s", symbol)
+		}
+	}
+
+	conn := NewConnectionTo(ln.Addr().String(), &Credentials{Username: "user"})
+	ch1 := make(chan Message)
+	ch2 := make(chan Message)
+	conn.RegisterMarketUpdate([]string{"ESZ9"}, ch1)
+	go conn.Start()
+	expect(subscribed, "ESZ9")
+
+	conn.RegisterMarketUpdate([]string{"CLZ9"}, ch1)
+	expect(subscribed, "CLZ9")
+
+	// CLZ9 stays subscribed while ch2 still listens
+	conn.RegisterMarketUpdate([]string{"CLZ9"}, ch2)
+	conn.UnregisterMarketUpdate([]string{"CLZ9", "ESZ9"}, ch1)
+	expect(unsubscribed, "ESZ9")
+
+	conn.UnregisterMarketUpdate([]string{"CLZ9"}, ch2)
+	expect(unsubscribed, "CLZ9")
+}
+
+func TestConnectionReconnect(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subscribed := make(chan []string, 4)
+
+	server := NewServer()
+	server.OnSubscribe = func(c *ServerClient, symbols []string) {
+		subscribed <- symbols
+		c.Close()
+	}
+	go server.Serve(ln)
+	defer server.Close()
+
+	conn := NewConnectionTo(ln.Addr().String(), &Credentials{Username: "user"})
+	conn.reconnectDelay = 10 * time.Millisecond
+	conn.SetReconnect(true)
+	conn.RegisterMarketUpdate([]string{"ESZ9"}, make(chan Message))
+	go conn.Start()
+
+	// The server drops every session, and each new one subscribes again
+	for i := 0; i < 2; i++ {
+		select {
+		case symbols := <-subscribed:
+			if len(symbols) != 1 || symbols[0] != "ESZ9" {
+				t.Fatalf("unexpected subscription %v", symbols)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no session %d", i+1)
+		}
+	}
+}
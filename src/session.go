@@ -0,0 +1,126 @@
+// Go ddfplus API
+//
+// Copyright 2019 Barchart.com, Inc. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the GNU license
+// available at https://github.com/barchart/go-ddfplus-api/blob/master/LICENSE.
+package ddf
+
+import (
+	"fmt"
+	"time"
+)
+
+type SessionKind int
+
+const (
+	SessionUnknown SessionKind = iota
+	SessionRegular
+	SessionElectronic
+	SessionPreMarket
+	SessionPostMarket
+	SessionCombined
+)
+
+func (k SessionKind) String() string {
+	switch k {
+	case SessionRegular:
+		return "regular"
+	case SessionElectronic:
+		return "electronic"
+	case SessionPreMarket:
+		return "pre-market"
+	case SessionPostMarket:
+		return "post-market"
+	case SessionCombined:
+		return "combined"
+	}
+
+	return "unknown"
+}
+
+// ParseSessionKind maps a DDF session code to its session kind.
+func ParseSessionKind(b byte) SessionKind {
+	switch b {
+	case ' ', 'R':
+		return SessionRegular
+	case 'G':
+		return SessionElectronic
+	case 'P':
+		return SessionPreMarket
+	case 'T':
+		return SessionPostMarket
+	case 'Z':
+		return SessionCombined
+	}
+
+	return SessionUnknown
+}
+
+// DecodeDayCode converts a DDF day code ('1'-'9', '0' for 10, 'A'-'U' for
+// 11-31) to a day of the month.
+func DecodeDayCode(b byte) (int, error) {
+	switch {
+	case b >= '1' && b <= '9':
+		return int(b - '0'), nil
+	case b == '0':
+		return 10, nil
+	case b >= 'A' && b <= 'U':
+		return int(b-'A') + 11, nil
+	}
+
+	return 0, fmt.Errorf("invalid day code %d", b)
+}
+
+// EncodeDayCode converts a day of the month to its DDF day code.
+func EncodeDayCode(day int) (byte, error) {
+	switch {
+	case day >= 1 && day <= 9:
+		return byte('0' + day), nil
+	case day == 10:
+		return '0', nil
+	case day >= 11 && day <= 31:
+		return byte('A' + day - 11), nil
+	}
+
+	return 0, fmt.Errorf("invalid day %d", day)
+}
+
+// TradingDate resolves a DDF day code to a trading date, using ref (usually
+// the message timestamp) to find the month. A day far ahead of ref belongs to
+// the previous month, a day far behind it to the next month.
+func TradingDate(b byte, ref time.Time) (time.Time, error) {
+	day, err := DecodeDayCode(b)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if ref.IsZero() {
+		ref = time.Now()
+	}
+	loc := Location()
+	ref = ref.In(loc)
+
+	year, month := ref.Year(), ref.Month()
+	switch diff := day - ref.Day(); {
+	case diff > 15:
+		month--
+	case diff < -15:
+		month++
+	}
+
+	// time.Date would roll an out of range day into the following month
+	t := time.Date(year, month, day, 0, 0, 0, 0, loc)
+	if t.Day() != day {
+		return time.Time{}, fmt.Errorf("invalid trading day %d", day)
+	}
+
+	return t, nil
+}
+
+func firstByte(s string) byte {
+	if len(s) == 0 {
+		return 0
+	}
+	return s[0]
+}
@@ -0,0 +1,118 @@
+// Go ddfplus API
+//
+// Copyright 2019 Barchart.com, Inc. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the GNU license
+// available at https://github.com/barchart/go-ddfplus-api/blob/master/LICENSE.
+package ddf
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDecodeDayCode(t *testing.T) {
+	for _, c := range []struct {
+		code byte
+		day  int
+	}{
+		{'1', 1}, {'9', 9}, {'0', 10}, {'A', 11}, {'K', 21}, {'U', 31},
+		{'V', 0}, {'a', 0}, {0, 0},
+	} {
+		day, err := DecodeDayCode(c.code)
+		if day != c.day || (err != nil) != (c.day == 0) {
+			t.Errorf("%q decoded as %d, %v, expected %d", c.code, day, err, c.day)
+		}
+
+		if c.day == 0 {
+			continue
+		}
+
+		code, err := EncodeDayCode(day)
+		if err != nil || code != c.code {
+			t.Errorf("%d encoded as %q, %v, expected %q", day, code, err, c.code)
+		}
+	}
+}
+
+func TestTradingDate(t *testing.T) {
+	loc := Location()
+	date := func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d, 0, 0, 0, 0, loc)
+	}
+
+	for _, c := range []struct {
+		name string
+		code byte
+		ref  time.Time
+		want time.Time
+	}{
+		{"same day", '6', time.Date(2019, 11, 6, 9, 30, 0, 0, loc), date(2019, 11, 6)},
+		{"next day", '7', time.Date(2019, 11, 6, 17, 0, 0, 0, loc), date(2019, 11, 7)},
+		{"15 days ahead", 'U', date(2019, 10, 16), date(2019, 10, 31)},
+		{"16 days ahead is last month", 'U', date(2019, 11, 15), date(2019, 10, 31)},
+		{"16 days behind is next month", '1', date(2019, 11, 17), date(2019, 12, 1)},
+		{"next month", '1', time.Date(2019, 11, 30, 17, 0, 0, 0, loc), date(2019, 12, 1)},
+		{"next year", '2', time.Date(2019, 12, 31, 17, 0, 0, 0, loc), date(2020, 1, 2)},
+		{"previous year", 'U', time.Date(2020, 1, 1, 8, 0, 0, 0, loc), date(2019, 12, 31)},
+		{"leap day", 'S', date(2020, 2, 28), date(2020, 2, 29)},
+		{"reference in UTC", '6', time.Date(2019, 11, 7, 2, 0, 0, 0, time.UTC), date(2019, 11, 6)},
+	} {
+		got, err := TradingDate(c.code, c.ref)
+		if err != nil || !got.Equal(c.want) {
+			t.Errorf("%s: %q from %v is %v, %v, expected %v", c.name, c.code, c.ref, got, err, c.want)
+		}
+	}
+
+	// There is no February 30
+	_, err := TradingDate('T', date(2019, 2, 27))
+	if err == nil {
+		t.Error("expected an error for February 30")
+	}
+
+	_, err = TradingDate('V', date(2019, 11, 6))
+	if err == nil {
+		t.Error("expected an error for an invalid day code")
+	}
+}
+
+func TestTradingDateNow(t *testing.T) {
+	now := time.Now().In(Location())
+	code, _ := EncodeDayCode(now.Day())
+
+	got, err := TradingDate(code, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The day may have just turned
+	y, m, d := got.Date()
+	if y != now.Year() || m != now.Month() || d != now.Day() {
+		if now2 := time.Now().In(Location()); now2.Day() == now.Day() {
+			t.Errorf("expected today %v, got %v", now, got)
+		}
+	}
+}
+
+func TestSessionByKind(t *testing.T) {
+	ts := time.Date(2019, 11, 1, 9, 30, 0, 0, Location())
+	m := MessageRefresh{Sessions: map[string]RefreshSession{
+		"combined": {ID: "combined", Session: "G", Timestamp: ts.Add(time.Hour)},
+		"a":        {ID: "a", Session: "G", Timestamp: ts},
+		"b":        {ID: "b", Session: "G", Timestamp: ts.Add(time.Minute)},
+		"c":        {ID: "c", Session: "G", Timestamp: ts.Add(time.Minute)},
+		"d":        {ID: "d", Session: "R", Timestamp: ts},
+	}}
+
+	// Map order changes between runs, the result must not
+	for i := 0; i < 20; i++ {
+		s, ok := m.SessionByKind(SessionElectronic)
+		if !ok || s.ID != "b" {
+			t.Fatalf("unexpected electronic session %+v", s)
+		}
+	}
+
+	if _, ok := m.SessionByKind(SessionPreMarket); ok {
+		t.Error("unexpected pre-market session")
+	}
+}
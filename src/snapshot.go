@@ -0,0 +1,160 @@
+// Go ddfplus API
+//
+// Copyright 2019 Barchart.com, Inc. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the GNU license
+// available at https://github.com/barchart/go-ddfplus-api/blob/master/LICENSE.
+package ddf
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"time"
+)
+
+const snapshotVersion = 1
+
+// snapshot is the JSON document written by SaveSnapshot. Version changes
+// whenever a change to Quote would not load into the old fields.
+type snapshot struct {
+	Version   int       `json:"version"`
+	Saved     time.Time `json:"saved"`
+	Timestamp time.Time `json:"timestamp"`
+	Quotes    []*Quote  `json:"quotes"`
+}
+
+// SaveSnapshot writes every quote in the DB to w as JSON, sorted by symbol.
+func (db *DB) SaveSnapshot(w io.Writer) error {
+	db.mu.RLock()
+	s := snapshot{
+		Version:   snapshotVersion,
+		Saved:     time.Now(),
+		Timestamp: db.timestamp,
+		Quotes:    make([]*Quote, 0, len(db.data)),
+	}
+	for _, q := range db.data {
+		c := *q
+		s.Quotes = append(s.Quotes, &c)
+	}
+	db.mu.RUnlock()
+
+	sort.Slice(s.Quotes, func(i, j int) bool {
+		return s.Quotes[i].Symbol < s.Quotes[j].Symbol
+	})
+
+	return json.NewEncoder(w).Encode(&s)
+}
+
+// LoadSnapshot reads quotes written by SaveSnapshot into the DB, marking
+// them stale. Quotes already in the DB from the feed are kept. Listeners are
+// not sent the loaded quotes.
+func (db *DB) LoadSnapshot(r io.Reader) error {
+	var s snapshot
+	err := json.NewDecoder(r).Decode(&s)
+	if err != nil {
+		return fmt.Errorf("invalid snapshot. %v", err)
+	}
+
+	if s.Version != snapshotVersion {
+		return fmt.Errorf("unsupported snapshot version %d", s.Version)
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for _, q := range s.Quotes {
+		if q == nil || q.Symbol == "" {
+			continue
+		}
+
+		if old := db.data[q.Symbol]; old != nil && !old.Stale {
+			continue
+		}
+
+		q.Stale = true
+		db.setQuote(q)
+	}
+
+	if db.timestamp.IsZero() {
+		db.timestamp = s.Timestamp
+	}
+
+	return nil
+}
+
+// SaveSnapshotFile writes a snapshot to a temporary file and renames it to
+// path, so that path always holds a complete snapshot.
+func (db *DB) SaveSnapshotFile(path string) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	err = db.SaveSnapshot(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// LoadSnapshotFile loads the snapshot at path. A missing file returns an
+// error for which os.IsNotExist is true.
+func (db *DB) LoadSnapshotFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return db.LoadSnapshot(f)
+}
+
+// Checkpoint saves a snapshot to path every interval until stop is called,
+// which saves a last one before returning.
+func (db *DB) Checkpoint(path string, interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+
+			err := db.SaveSnapshotFile(path)
+			if err != nil {
+				log.Printf("Error saving snapshot. %v", err)
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+
+		err := db.SaveSnapshotFile(path)
+		if err != nil {
+			log.Printf("Error saving snapshot. %v", err)
+		}
+	}
+}
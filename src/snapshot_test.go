@@ -0,0 +1,83 @@
+// Go ddfplus API
+//
+// Copyright 2019 Barchart.com, Inc. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the GNU license
+// available at https://github.com/barchart/go-ddfplus-api/blob/master/LICENSE.
+package ddf
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSnapshot(t *testing.T) {
+	db := InitDB()
+	ts := time.Date(2019, 11, 6, 9, 30, 0, 0, time.UTC)
+	db.Process(MessageTimestamp{Timestamp: ts})
+	db.Process(testRefresh())
+	db.Process(MessageTrade{Symbol: "ESZ9", Trade: 3001, TradeSize: 2, Timestamp: ts})
+	db.Process(MessageRefresh{Symbol: "CLZ9", BaseCode: "A", Bid: 56.5})
+
+	var buf bytes.Buffer
+	err := db.SaveSnapshot(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := InitDB()
+	loaded.Process(MessageRefresh{Symbol: "CLZ9", BaseCode: "A", Bid: 57})
+	err = loaded.LoadSnapshot(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := loaded.GetQuote("ESZ9")
+	want := db.GetQuote("ESZ9")
+	want.Stale = true
+	if q == nil || q.Info != want.Info || q.Derived != want.Derived || q.Data.CurrentSession.Last != 3001 ||
+		!q.Data.CurrentSession.TradeTime.Equal(ts) || q.Data.PreviousSession.Settlement != 2994.75 || !q.Stale {
+		t.Errorf("unexpected loaded quote %+v", q)
+	}
+
+	// The live quote wins over the snapshot
+	if q := loaded.GetQuote("CLZ9"); q.Data.CurrentSession.Bid != 57 || q.Stale {
+		t.Errorf("unexpected live quote %+v", q)
+	}
+
+	if !loaded.Timestamp().Equal(ts) {
+		t.Errorf("unexpected timestamp %v", loaded.Timestamp())
+	}
+
+	loaded.Process(testRefresh())
+	if loaded.GetQuote("ESZ9").Stale {
+		t.Error("expected a refresh to confirm the quote")
+	}
+
+	err = loaded.LoadSnapshot(strings.NewReader(`{"version":99,"quotes":[]}`))
+	if err == nil {
+		t.Error("expected an error for an unknown version")
+	}
+}
+
+func TestCheckpoint(t *testing.T) {
+	db := InitDB()
+	db.Process(testRefresh())
+
+	path := filepath.Join(t.TempDir(), "ddf.snapshot")
+	stop := db.Checkpoint(path, time.Hour)
+	stop()
+
+	loaded := InitDB()
+	err := loaded.LoadSnapshotFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if q := loaded.GetQuote("ESZ9"); q == nil || !q.Stale {
+		t.Errorf("unexpected quote %+v", q)
+	}
+}
@@ -0,0 +1,208 @@
+// Go ddfplus API
+//
+// Copyright 2019 Barchart.com, Inc. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the GNU license
+// available at https://github.com/barchart/go-ddfplus-api/blob/master/LICENSE.
+package ddf
+
+import (
+	"sort"
+	"time"
+)
+
+// StaleThresholds set how long a quote may go without any message before it
+// is inactive. The threshold of the quote's DDF exchange wins over the one
+// of its symbol type, which wins over Default. A zero threshold never makes
+// a quote inactive.
+type StaleThresholds struct {
+	Default   time.Duration
+	Exchanges map[string]time.Duration // by Quote.Info.DDFExchange, such as "M"
+	Types     map[SymbolType]time.Duration
+}
+
+// StaleEvent reports that a quote became stale, or stopped being stale.
+type StaleEvent struct {
+	Symbol       string
+	Stale        bool // Quote.IsStale
+	Inactive     bool
+	LastActivity time.Time
+	Timestamp    time.Time
+}
+
+// IsStale reports whether the quote is not to be trusted as current, because
+// it came from a snapshot or has gone inactive.
+func (q *Quote) IsStale() bool {
+	return q.Stale || q.Inactive
+}
+
+// Closed reports whether the flag of the last refresh marks the market as
+// closed ("c") or settled ("s"). ddfplus has no halt message, so this is
+// the only status the feed carries. Closed quotes do not go inactive.
+func (q *Quote) Closed() bool {
+	return q.Info.Flag == "c" || q.Info.Flag == "s"
+}
+
+// SetStaleThresholds sets when quotes go inactive. Thresholds are checked
+// on each timestamp message, against the feed time.
+func (db *DB) SetStaleThresholds(thresholds StaleThresholds) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.staleThresholds = thresholds
+}
+
+// threshold returns the stale threshold of q. The DB lock must be held.
+func (db *DB) threshold(q *Quote) time.Duration {
+	t := db.staleThresholds
+	if d, ok := t.Exchanges[q.Info.DDFExchange]; ok {
+		return d
+	}
+	if d, ok := t.Types[db.symbols[q.Symbol].Type]; ok {
+		return d
+	}
+
+	return t.Default
+}
+
+func staleEvent(q *Quote, ts time.Time) StaleEvent {
+	return StaleEvent{
+		Symbol:       q.Symbol,
+		Stale:        q.IsStale(),
+		Inactive:     q.Inactive,
+		LastActivity: q.LastActivity,
+		Timestamp:    ts,
+	}
+}
+
+// messageTime returns the time a message carries, or the zero time for
+// messages without one.
+func messageTime(m Message) time.Time {
+	switch m := m.(type) {
+	case MessageTrade:
+		return m.Timestamp
+	case MessageBidAsk:
+		return m.Timestamp
+	case MessageRefresh:
+		if !m.LastUpdate.IsZero() {
+			return m.LastUpdate
+		}
+		return m.CurrentSession.Timestamp
+	}
+
+	return time.Time{}
+}
+
+// touch records activity on symbol at t, the time of the message, or the
+// feed time if t is zero, returning an event if that ends its staleness. old
+// is the quote before the message. Without either time LastActivity is left
+// as it is, as the wall clock would be wrong in a replay. The DB lock must be
+// held.
+func (db *DB) touch(symbol string, old *Quote, t time.Time) *StaleEvent {
+	q := db.data[symbol]
+	if q == nil {
+		return nil
+	}
+
+	if t.IsZero() {
+		t = db.timestamp
+	}
+	if t.After(q.LastActivity) {
+		q.LastActivity = t
+	}
+	q.Inactive = false
+
+	if old == nil || old.IsStale() == q.IsStale() {
+		return nil
+	}
+
+	e := staleEvent(q, db.now())
+	return &e
+}
+
+// checkActivity marks the quotes without activity for longer than their
+// threshold as inactive, returning an event for each that was not already
+// stale, and copies of all of them from before the change, sorted by symbol.
+// The DB lock must be held.
+func (db *DB) checkActivity() ([]StaleEvent, []*Quote) {
+	now := db.now()
+
+	var (
+		events []StaleEvent
+		old    []*Quote
+	)
+	for _, q := range db.data {
+		// A quote without a known activity time is not judged
+		if q.Inactive || q.Closed() || q.LastActivity.IsZero() {
+			continue
+		}
+
+		d := db.threshold(q)
+		if d <= 0 || now.Sub(q.LastActivity) <= d {
+			continue
+		}
+
+		c := *q
+		old = append(old, &c)
+
+		q.Inactive = true
+		if !c.IsStale() {
+			events = append(events, staleEvent(q, now))
+		}
+	}
+
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].Symbol < events[j].Symbol
+	})
+	sort.Slice(old, func(i, j int) bool {
+		return old[i].Symbol < old[j].Symbol
+	})
+
+	return events, old
+}
+
+// Stale returns copies of the stale quotes, sorted by symbol.
+func (db *DB) Stale() []*Quote {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var quotes []*Quote
+	for _, q := range db.data {
+		if q.IsStale() {
+			c := *q
+			quotes = append(quotes, &c)
+		}
+	}
+
+	sort.Slice(quotes, func(i, j int) bool {
+		return quotes[i].Symbol < quotes[j].Symbol
+	})
+
+	return quotes
+}
+
+// RegisterStale sends ch an event each time a quote becomes stale or stops
+// being stale.
+func (db *DB) RegisterStale(ch chan StaleEvent) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for _, l := range db.staleListeners {
+		if l == ch {
+			return
+		}
+	}
+
+	// Build a new slice, as Process may be ranging over the old one
+	updated := make([]chan StaleEvent, 0, len(db.staleListeners)+1)
+	updated = append(updated, db.staleListeners...)
+	db.staleListeners = append(updated, ch)
+}
+
+func sendStaleEvents(listeners []chan StaleEvent, events []StaleEvent) {
+	for _, e := range events {
+		for _, ch := range listeners {
+			ch <- e
+		}
+	}
+}
@@ -0,0 +1,141 @@
+// Go ddfplus API
+//
+// Copyright 2019 Barchart.com, Inc. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the GNU license
+// available at https://github.com/barchart/go-ddfplus-api/blob/master/LICENSE.
+package ddf
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStale(t *testing.T) {
+	db := InitDB()
+	db.SetStaleThresholds(StaleThresholds{
+		Default:   time.Minute,
+		Exchanges: map[string]time.Duration{"N": 0},
+		Types:     map[SymbolType]time.Duration{FutureOption: 5 * time.Minute},
+	})
+
+	events := make(chan StaleEvent, 10)
+	db.RegisterStale(events)
+
+	start := time.Date(2019, 11, 6, 9, 30, 0, 0, time.UTC)
+	db.Process(MessageTimestamp{Timestamp: start})
+	for _, s := range []string{"ESZ9", "ESZ3000C", "GCZ9"} {
+		db.Process(MessageRefresh{Symbol: s, BaseCode: "A", DDFExchange: "M"})
+	}
+	db.Process(MessageRefresh{Symbol: "CLZ9", BaseCode: "A", DDFExchange: "N"})
+
+	// A closed market is not expected to tick
+	db.Process(MessageRefresh{Symbol: "NGZ9", BaseCode: "A", DDFExchange: "M", Flag: "c"})
+
+	if q := db.GetQuote("ESZ9"); !q.LastActivity.Equal(start) {
+		t.Errorf("unexpected last activity %v", q.LastActivity)
+	}
+
+	// A book message is activity too
+	db.Process(MessageTimestamp{Timestamp: start.Add(30 * time.Second)})
+	db.Process(MessageBook{Symbol: "GCZ9", BaseCode: "A"})
+
+	db.Process(MessageTimestamp{Timestamp: start.Add(61 * time.Second)})
+	e := <-events
+	if e.Symbol != "ESZ9" || !e.Stale || !e.Inactive || !e.LastActivity.Equal(start) {
+		t.Errorf("unexpected event %+v", e)
+	}
+	if len(events) != 0 {
+		t.Errorf("unexpected event %+v", <-events)
+	}
+
+	db.Process(MessageTimestamp{Timestamp: start.Add(10 * time.Minute)})
+	if s := symbolsOf(db.Stale()); s != "ESZ3000C,ESZ9,GCZ9" {
+		t.Errorf("unexpected stale quotes %s", s)
+	}
+	<-events
+	<-events
+
+	db.Process(MessageTrade{Symbol: "ESZ9", Trade: 3000, TradeSize: 1})
+	e = <-events
+	if e.Symbol != "ESZ9" || e.Stale || db.GetQuote("ESZ9").Inactive {
+		t.Errorf("unexpected event after a trade %+v", e)
+	}
+}
+
+func TestStaleListeners(t *testing.T) {
+	db := InitDB()
+	db.SetStaleThresholds(StaleThresholds{Default: time.Minute})
+
+	start := time.Date(2019, 11, 6, 9, 30, 0, 0, time.UTC)
+	db.Process(MessageTimestamp{Timestamp: start})
+	db.Process(MessageRefresh{Symbol: "ESZ9", BaseCode: "A", DDFExchange: "M"})
+
+	quotes := make(chan *Quote, 10)
+	deltas := make(chan QuoteDelta, 10)
+	chain := make(chan ChainEvent, 10)
+	db.Register([]string{"ESZ9"}, quotes)
+	db.RegisterDelta([]string{"ESZ9"}, FieldInactive, deltas)
+	db.RegisterChain("ES", chain)
+
+	// Activity alone is not a change to the inactive field
+	db.Process(MessageTimestamp{Timestamp: start.Add(30 * time.Second)})
+	db.Process(MessageBidAsk{Symbol: "ESZ9", Bid: 3000, BidSize: 1})
+	<-quotes
+	<-chain
+	if len(deltas) != 0 {
+		t.Errorf("unexpected delta %+v", <-deltas)
+	}
+
+	db.Process(MessageTimestamp{Timestamp: start.Add(91 * time.Second)})
+	if q := <-quotes; !q.Inactive {
+		t.Errorf("unexpected quote %+v", q)
+	}
+	if e := <-chain; e.Symbol != "ESZ9" || !e.Quote.Inactive {
+		t.Errorf("unexpected chain event %+v", e)
+	}
+	d := <-deltas
+	if d.Type != Timestamp || d.Fields != FieldInactive || d.Old.Inactive || !d.New.Inactive {
+		t.Errorf("unexpected delta %v %+v", d.Fields, d)
+	}
+
+	// Only the change to inactive is sent
+	db.Process(MessageTimestamp{Timestamp: start.Add(5 * time.Minute)})
+	if len(quotes) != 0 || len(deltas) != 0 || len(chain) != 0 {
+		t.Errorf("unexpected updates while inactive")
+	}
+}
+
+func TestStaleActivityTime(t *testing.T) {
+	db := InitDB()
+	db.SetStaleThresholds(StaleThresholds{Default: time.Minute})
+
+	// Before any timestamp, as when replaying, the message times are used
+	ts := time.Date(2019, 11, 6, 9, 30, 0, 0, Location())
+	db.Process(MessageRefresh{Symbol: "ESZ9", BaseCode: "A", LastUpdate: ts.Add(-time.Minute)})
+	if q := db.GetQuote("ESZ9"); !q.LastActivity.Equal(ts.Add(-time.Minute)) {
+		t.Errorf("unexpected last activity %v", q.LastActivity)
+	}
+
+	db.Process(MessageTrade{Symbol: "ESZ9", Trade: 3000, TradeSize: 1, Timestamp: ts})
+	if q := db.GetQuote("ESZ9"); !q.LastActivity.Equal(ts) {
+		t.Errorf("unexpected last activity %v", q.LastActivity)
+	}
+
+	// A message without a time is activity without one
+	db.Process(MessageRefresh{Symbol: "CLZ9", BaseCode: "A"})
+	if q := db.GetQuote("CLZ9"); !q.LastActivity.IsZero() {
+		t.Errorf("unexpected last activity %v", q.LastActivity)
+	}
+
+	db.Process(MessageTimestamp{Timestamp: ts.Add(2 * time.Minute)})
+	if s := symbolsOf(db.Stale()); s != "ESZ9" {
+		t.Errorf("unexpected stale quotes %s", s)
+	}
+
+	// Later messages use the feed time when they have none
+	db.Process(MessageRefresh{Symbol: "CLZ9", BaseCode: "A"})
+	if q := db.GetQuote("CLZ9"); !q.LastActivity.Equal(ts.Add(2 * time.Minute)) {
+		t.Errorf("unexpected last activity %v", q.LastActivity)
+	}
+}
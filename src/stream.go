@@ -0,0 +1,430 @@
+// Go ddfplus API
+//
+// Copyright 2019 Barchart.com, Inc. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the GNU license
+// available at https://github.com/barchart/go-ddfplus-api/blob/master/LICENSE.
+package ddf
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// StreamHandler streams quotes from a DB over Server-Sent Events, or over a
+// WebSocket when the request asks for an upgrade. Symbols are picked with
+// ?symbols=A,B and the throttling interval with ?interval=250ms (or a number
+// of milliseconds).
+//
+// Each symbol starts with a snapshot event carrying the full quote, followed
+// by delta events with only the fields that changed, at most once per
+// interval. Fields are named as in QuoteField:
+//
+//	{"type":"snapshot","symbol":"ESZ9","quote":{...}}
+//	{"type":"delta","symbol":"ESZ9","fields":{"bid":3001.25}}
+//
+// SSE sends these as the data of "snapshot" and "delta" events. WebSocket
+// clients can change their subscription by sending
+//
+//	{"subscribe":["CLZ9"],"unsubscribe":["ESZ9"],"interval":1000}
+type StreamHandler struct {
+	db *DB
+
+	// Interval is the throttling interval for clients that don't ask for
+	// one, and MinInterval the shortest they may ask for.
+	Interval    time.Duration
+	MinInterval time.Duration
+
+	// MaxSymbols limits the symbols of one stream. Zero is no limit.
+	MaxSymbols int
+
+	// MaxSubscriptions limits the symbols auto subscribed to, across all
+	// streams. Zero is no limit.
+	MaxSubscriptions int
+
+	// AllowSymbol reports whether a symbol may be auto subscribed to. nil
+	// allows any symbol.
+	AllowSymbol func(symbol string) bool
+
+	// SubscribeTimeout is how long an auto subscribed symbol may go without
+	// a refresh before it is unsubscribed.
+	SubscribeTimeout time.Duration
+
+	// CheckOrigin reports whether a WebSocket handshake may go ahead. nil
+	// only allows requests without an Origin header or from the same host.
+	CheckOrigin func(r *http.Request) bool
+
+	subscriber autoSubscriber
+}
+
+type streamEvent struct {
+	Type   string                 `json:"type"`
+	Symbol string                 `json:"symbol"`
+	Quote  *Quote                 `json:"quote,omitempty"`
+	Fields map[string]interface{} `json:"fields,omitempty"`
+}
+
+type streamRequest struct {
+	Subscribe   []string `json:"subscribe"`
+	Unsubscribe []string `json:"unsubscribe"`
+	Interval    int64    `json:"interval"`
+}
+
+func NewStreamHandler(db *DB) *StreamHandler {
+	return &StreamHandler{
+		db:               db,
+		Interval:         250 * time.Millisecond,
+		MinInterval:      50 * time.Millisecond,
+		MaxSubscriptions: 1000,
+		SubscribeTimeout: 10 * time.Second,
+	}
+}
+
+// AutoSubscribe subscribes conn to symbols the first time they are streamed
+// and are not already in the DB. The DB must be connected to conn. Their
+// snapshots are sent once the refreshes arrive.
+func (h *StreamHandler) AutoSubscribe(conn *Connection) {
+	h.subscriber.set(conn)
+}
+
+// subscribe auto subscribes to symbols, unsubscribing the ones without a
+// refresh after SubscribeTimeout.
+func (h *StreamHandler) subscribe(symbols []string) {
+	added := h.subscriber.subscribe(h.db, symbols, h.MaxSubscriptions, h.AllowSymbol)
+	if len(added) == 0 {
+		return
+	}
+
+	time.AfterFunc(h.SubscribeTimeout, func() {
+		h.subscriber.expire(h.db, added)
+	})
+}
+
+// quoteStream coalesces the DB deltas for one client between flushes.
+type quoteStream struct {
+	db         *DB
+	maxSymbols int
+	updates    chan QuoteDelta
+	done       chan struct{}
+
+	mu      sync.Mutex
+	symbols map[string]bool
+	pending map[string]*QuoteDelta
+	sent    map[string]bool
+}
+
+func newQuoteStream(db *DB, maxSymbols int) *quoteStream {
+	s := &quoteStream{
+		db:         db,
+		maxSymbols: maxSymbols,
+		updates:    make(chan QuoteDelta, 256),
+		done:       make(chan struct{}),
+		symbols:    make(map[string]bool),
+		pending:    make(map[string]*QuoteDelta),
+		sent:       make(map[string]bool),
+	}
+	go s.collect()
+
+	return s
+}
+
+func (s *quoteStream) collect() {
+	for {
+		select {
+		case d := <-s.updates:
+			s.mu.Lock()
+			if s.symbols[d.Symbol] {
+				if p := s.pending[d.Symbol]; p != nil {
+					p.Fields |= d.Fields
+					p.New = d.New
+				} else {
+					s.pending[d.Symbol] = &d
+				}
+			}
+			s.mu.Unlock()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// add returns the symbols added, leaving out those already streamed or over
+// the limit.
+func (s *quoteStream) add(symbols []string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var added []string
+	for _, symbol := range symbols {
+		if s.symbols[symbol] || (s.maxSymbols > 0 && len(s.symbols) >= s.maxSymbols) {
+			continue
+		}
+
+		s.symbols[symbol] = true
+		added = append(added, symbol)
+		if q := s.db.GetQuote(symbol); q != nil {
+			s.pending[symbol] = &QuoteDelta{Symbol: symbol, New: q}
+		}
+	}
+
+	s.db.RegisterDelta(added, FieldAll, s.updates)
+
+	return added
+}
+
+func (s *quoteStream) remove(symbols []string) {
+	// UnregisterDelta waits for collect to take any delta in flight, which
+	// needs the lock
+	s.db.UnregisterDelta(symbols, s.updates)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, symbol := range symbols {
+		delete(s.symbols, symbol)
+		delete(s.pending, symbol)
+		delete(s.sent, symbol)
+	}
+}
+
+func (s *quoteStream) close() {
+	s.mu.Lock()
+	symbols := make([]string, 0, len(s.symbols))
+	for symbol := range s.symbols {
+		symbols = append(symbols, symbol)
+	}
+	s.mu.Unlock()
+
+	// UnregisterDelta returns once the DB is done sending to updates, so
+	// collect must run until then
+	s.db.UnregisterDelta(symbols, s.updates)
+	close(s.done)
+}
+
+// flush returns the events for the quotes updated since the last flush: a
+// snapshot for symbols not sent before, otherwise the changed fields.
+func (s *quoteStream) flush() []streamEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	symbols := make([]string, 0, len(s.pending))
+	for symbol := range s.pending {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+
+	var events []streamEvent
+	for _, symbol := range symbols {
+		d := s.pending[symbol]
+		delete(s.pending, symbol)
+
+		if !s.sent[symbol] {
+			s.sent[symbol] = true
+			events = append(events, streamEvent{Type: "snapshot", Symbol: symbol, Quote: d.New})
+			continue
+		}
+
+		fields := make(map[string]interface{})
+		for _, c := range d.Changes() {
+			fields[c.Name] = c.New
+		}
+
+		if len(fields) > 0 {
+			events = append(events, streamEvent{Type: "delta", Symbol: symbol, Fields: fields})
+		}
+	}
+
+	return events
+}
+
+// parseInterval reads a duration such as "250ms", or a plain number of
+// milliseconds.
+func parseInterval(s string) (time.Duration, error) {
+	if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Duration(ms) * time.Millisecond, nil
+	}
+
+	return time.ParseDuration(s)
+}
+
+func (h *StreamHandler) interval(d time.Duration) time.Duration {
+	if d <= 0 {
+		return h.Interval
+	}
+
+	if d < h.MinInterval {
+		return h.MinInterval
+	}
+
+	return d
+}
+
+func (h *StreamHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var interval time.Duration
+	if v := r.URL.Query().Get("interval"); v != "" {
+		d, err := parseInterval(v)
+		if err != nil {
+			http.Error(w, "invalid interval "+v, http.StatusBadRequest)
+			return
+		}
+		interval = d
+	}
+
+	symbols := parseSymbolList(r.URL.Query().Get("symbols"))
+
+	if isWebSocketRequest(r) {
+		h.serveWebSocket(w, r, symbols, h.interval(interval))
+	} else {
+		h.serveSSE(w, r, symbols, h.interval(interval))
+	}
+}
+
+func (h *StreamHandler) serveSSE(w http.ResponseWriter, r *http.Request, symbols []string, interval time.Duration) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	if len(symbols) == 0 {
+		http.Error(w, "missing symbols", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	s := newQuoteStream(h.db, h.MaxSymbols)
+	defer s.close()
+	h.subscribe(s.add(symbols))
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	heartbeat := time.NewTicker(15 * time.Second)
+	defer heartbeat.Stop()
+
+	for {
+		for _, e := range s.flush() {
+			b, err := json.Marshal(e)
+			if err != nil {
+				log.Printf("Error encoding %s for %s. %v", e.Type, e.Symbol, err)
+				continue
+			}
+
+			_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, b)
+			if err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			_, err := fmt.Fprintf(w, ": ping\n\n")
+			if err != nil {
+				return
+			}
+		case <-ticker.C:
+		}
+	}
+}
+
+func (h *StreamHandler) serveWebSocket(w http.ResponseWriter, r *http.Request, symbols []string, interval time.Duration) {
+	checkOrigin := h.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = SameOrigin
+	}
+	if !checkOrigin(r) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+
+	ws, err := upgradeWebSocket(w, r)
+	if err != nil {
+		return
+	}
+	defer ws.Close()
+
+	s := newQuoteStream(h.db, h.MaxSymbols)
+	defer s.close()
+	h.subscribe(s.add(symbols))
+
+	var (
+		intervals = make(chan time.Duration, 1)
+		closed    = make(chan struct{})
+	)
+
+	go func() {
+		defer close(closed)
+		for {
+			message, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+
+			var req streamRequest
+			err = json.Unmarshal(message, &req)
+			if err != nil {
+				b, _ := json.Marshal(httpError{"invalid request. " + err.Error()})
+				ws.WriteText(b)
+				continue
+			}
+
+			s.remove(req.Unsubscribe)
+			h.subscribe(s.add(req.Subscribe))
+
+			if req.Interval > 0 {
+				select {
+				case <-intervals:
+				default:
+				}
+				intervals <- h.interval(time.Duration(req.Interval) * time.Millisecond)
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	ping := time.NewTicker(30 * time.Second)
+	defer ping.Stop()
+
+	for {
+		for _, e := range s.flush() {
+			b, err := json.Marshal(e)
+			if err != nil {
+				log.Printf("Error encoding %s for %s. %v", e.Type, e.Symbol, err)
+				continue
+			}
+
+			err = ws.WriteText(b)
+			if err != nil {
+				return
+			}
+		}
+
+		select {
+		case <-closed:
+			return
+		case d := <-intervals:
+			ticker.Reset(d)
+		case <-ping.C:
+			if ws.Ping() != nil {
+				return
+			}
+		case <-ticker.C:
+		}
+	}
+}
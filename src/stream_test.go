@@ -0,0 +1,238 @@
+// Go ddfplus API
+//
+// Copyright 2019 Barchart.com, Inc. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the GNU license
+// available at https://github.com/barchart/go-ddfplus-api/blob/master/LICENSE.
+package ddf
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newStreamTestDB() *DB {
+	db := InitDB()
+	db.Process(MessageRefresh{Symbol: "ESZ9", BaseCode: "A", Bid: 3000.25, Ask: 3000.5})
+	db.Process(MessageRefresh{Symbol: "CLZ9", BaseCode: "A", Bid: 56.01, Ask: 56.02})
+
+	return db
+}
+
+func TestStreamSSE(t *testing.T) {
+	db := newStreamTestDB()
+	h := NewStreamHandler(db)
+	h.Interval = 10 * time.Millisecond
+
+	server := httptest.NewServer(h)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/?symbols=ESZ9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("unexpected content type %s", ct)
+	}
+
+	r := bufio.NewReader(resp.Body)
+	next := func() (string, streamEvent) {
+		t.Helper()
+		var (
+			event string
+			e     streamEvent
+		)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			line = strings.TrimSpace(line)
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				event = line[len("event: "):]
+			case strings.HasPrefix(line, "data: "):
+				err = json.Unmarshal([]byte(line[len("data: "):]), &e)
+				if err != nil {
+					t.Fatal(err)
+				}
+			case line == "" && event != "":
+				return event, e
+			}
+		}
+	}
+
+	event, e := next()
+	if event != "snapshot" || e.Symbol != "ESZ9" || e.Quote == nil || e.Quote.Data.CurrentSession.Bid != 3000.25 {
+		t.Fatalf("unexpected first event %s %+v", event, e)
+	}
+
+	db.Process(MessageBidAsk{Symbol: "ESZ9", Bid: 3000.5, BidSize: 3, Ask: 3000.75})
+	db.Process(MessageBidAsk{Symbol: "CLZ9", Bid: 56.02, Ask: 56.03})
+
+	event, e = next()
+	if event != "delta" || e.Symbol != "ESZ9" {
+		t.Fatalf("unexpected delta %s %+v", event, e)
+	}
+
+	if e.Fields["bid"] != 3000.5 || e.Fields["ask"] != 3000.75 || e.Fields["asksize"] != nil {
+		t.Errorf("unexpected fields %v", e.Fields)
+	}
+}
+
+// wsClientFrame encodes a masked client text frame.
+func wsClientFrame(payload []byte) []byte {
+	frame := []byte{0x80 | wsText, 0x80 | byte(len(payload)), 1, 2, 3, 4}
+	for i, b := range payload {
+		frame = append(frame, b^frame[2+i%4])
+	}
+
+	return frame
+}
+
+func TestStreamWebSocket(t *testing.T) {
+	db := newStreamTestDB()
+	h := NewStreamHandler(db)
+	h.Interval = 10 * time.Millisecond
+
+	server := httptest.NewServer(h)
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	io.WriteString(conn, "GET /?symbols=ESZ9 HTTP/1.1\r\n"+
+		"Host: localhost\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Sec-WebSocket-Version: 13\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n")
+
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-Websocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("unexpected handshake response %v %v", resp.Status, resp.Header)
+	}
+
+	next := func() streamEvent {
+		t.Helper()
+		var header [2]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			t.Fatal(err)
+		}
+
+		if header[0]&0x0F != wsText {
+			t.Fatalf("unexpected opcode %d", header[0]&0x0F)
+		}
+
+		n := int(header[1] & 0x7F)
+		if n == 126 {
+			var ext [2]byte
+			io.ReadFull(r, ext[:])
+			n = int(binary.BigEndian.Uint16(ext[:]))
+		}
+
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			t.Fatal(err)
+		}
+
+		var e streamEvent
+		err := json.Unmarshal(payload, &e)
+		if err != nil {
+			t.Fatalf("%v in %s", err, payload)
+		}
+
+		return e
+	}
+
+	if e := next(); e.Type != "snapshot" || e.Symbol != "ESZ9" {
+		t.Fatalf("unexpected first event %+v", e)
+	}
+
+	conn.Write(wsClientFrame([]byte(`{"subscribe":["CLZ9"],"unsubscribe":["ESZ9"]}`)))
+
+	if e := next(); e.Type != "snapshot" || e.Symbol != "CLZ9" {
+		t.Fatalf("unexpected event after subscribe %+v", e)
+	}
+
+	db.Process(MessageTrade{Symbol: "ESZ9", Trade: 3000.5, TradeSize: 1})
+	db.Process(MessageTrade{Symbol: "CLZ9", Trade: 56.02, TradeSize: 4})
+
+	if e := next(); e.Type != "delta" || e.Symbol != "CLZ9" || e.Fields["last"] != 56.02 {
+		t.Fatalf("unexpected delta %+v", e)
+	}
+}
+
+func TestStreamWebSocketOrigin(t *testing.T) {
+	h := NewStreamHandler(newStreamTestDB())
+	server := httptest.NewServer(h)
+	defer server.Close()
+
+	handshake := func(origin string) int {
+		t.Helper()
+		req, _ := http.NewRequest("GET", server.URL+"/?symbols=ESZ9", nil)
+		req.Host = "localhost"
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", "websocket")
+		req.Header.Set("Sec-WebSocket-Version", "13")
+		req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		return resp.StatusCode
+	}
+
+	tests := []struct {
+		origin string
+		status int
+	}{
+		{"", http.StatusSwitchingProtocols},
+		{"http://localhost", http.StatusSwitchingProtocols},
+		{"https://LOCALHOST", http.StatusSwitchingProtocols},
+		{"http://example.com", http.StatusForbidden},
+		{"http://localhost.example.com", http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		if status := handshake(test.origin); status != test.status {
+			t.Errorf("origin %q: expected status %d, got %d", test.origin, test.status, status)
+		}
+	}
+
+	h.CheckOrigin = func(r *http.Request) bool {
+		return r.Header.Get("Origin") == "http://example.com"
+	}
+	if status := handshake("http://example.com"); status != http.StatusSwitchingProtocols {
+		t.Errorf("allowed origin: expected status %d, got %d", http.StatusSwitchingProtocols, status)
+	}
+	if status := handshake(""); status != http.StatusForbidden {
+		t.Errorf("no origin: expected status %d, got %d", http.StatusForbidden, status)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type SymbolType int
@@ -60,10 +61,42 @@ func ParseSymbol(s string) (Symbol, error) {
 			symbol.Month = arr[2]
 			symbol.Strike, _ = strconv.Atoi(arr[3])
 			symbol.CallPut = arr[4]
-		} else {
-			fmt.Println("???", s)
 		}
 	}
 
 	return symbol, err
 }
+
+// monthCodes are the futures month codes, January to December.
+const monthCodes = "FGHJKMNQUVXZ"
+
+// ContractMonth returns the year and month of the contract. One digit years,
+// and options, which have no year, are taken as the first such month not
+// before ref. The result is false if the month code is unknown.
+func (s Symbol) ContractMonth(ref time.Time) (int, time.Month, bool) {
+	i := strings.Index(monthCodes, strings.ToUpper(s.Month))
+	if len(s.Month) != 1 || i < 0 {
+		return 0, 0, false
+	}
+	month := time.Month(i + 1)
+
+	var year int
+	switch {
+	case s.Type == FutureOption:
+		year = ref.Year()
+		if month < ref.Month() {
+			year++
+		}
+	case s.Year >= 100:
+		year = s.Year
+	case s.Year >= 10:
+		year = 2000 + s.Year
+	default:
+		year = ref.Year()/10*10 + s.Year
+		if year < ref.Year() {
+			year += 10
+		}
+	}
+
+	return year, month, true
+}
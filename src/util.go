@@ -7,183 +7,147 @@
 package ddf
 
 import (
+	"errors"
 	"fmt"
-	"strconv"
 )
 
+var (
+	errEmptyNumber   = errors.New("zero length string")
+	errInvalidNumber = errors.New("invalid number")
+)
+
+var decimalDivisors = [...]float64{1.0, 10.0, 100.0, 1000.0, 10000.0, 100000.0, 1000000.0, 10000000.0}
+
 func ParseFloat(s string, bc string) (float64, error) {
-	if len(s) == 0 {
-		return 0.0, fmt.Errorf("zero length string")
+	if len(bc) == 0 {
+		return 0.0, nil
+	}
+
+	return ParseFloatBytes([]byte(s), bc[0])
+}
+
+// ParseFloatBytes converts a DDF price in base code bc to a float without
+// allocating. Base codes '2' to '7' are fractional (8ths to 256ths), with
+// the numerator in the trailing digits; '8' to 'F' are decimal (0 to 7
+// decimal places).
+func ParseFloatBytes(b []byte, bc byte) (float64, error) {
+	if len(b) == 0 {
+		return 0.0, errEmptyNumber
 	}
 
 	var sign = 1.0
-	if s[0] == '-' {
-		s = s[1:]
+	if b[0] == '-' {
+		b = b[1:]
 		sign = -1.0
 	}
 
-	switch bc {
-	case "2": // 8ths
-
-		n, err := strconv.ParseFloat(s[0:len(s)-1], 64)
-		if err != nil {
-			return 0.0, err
-		}
-
-		d, err := strconv.ParseFloat(s[len(s)-1:], 64)
-		if err != nil {
-			return 0.0, err
-		}
-
-		return sign * (n + d/8), nil
-
-	case "3": // 16ths
-		if len(s) < 2 {
-			return 0.0, fmt.Errorf("Invalid length %d", len(s))
-		}
-
-		n, err := strconv.ParseFloat(s[0:len(s)-2], 64)
-		if err != nil {
-			return 0.0, err
-		}
-
-		d, err := strconv.ParseFloat(s[len(s)-2:], 64)
-		if err != nil {
-			return 0.0, err
-		}
-
-		return sign * (n + d/16), nil
-
-	case "4": // 32nds
-		if len(s) < 2 {
-			return 0.0, fmt.Errorf("Invalid length %d", len(s))
-		}
-
-		n, err := strconv.ParseFloat(s[0:len(s)-2], 64)
-		if err != nil {
-			return 0.0, err
-		}
-
-		d, err := strconv.ParseFloat(s[len(s)-2:], 64)
-		if err != nil {
-			return 0.0, err
-		}
-
-		return sign * (n + d/32), nil
+	var (
+		digits int
+		denom  float64
+	)
 
-	case "5": // 64th
-		if len(s) < 2 {
-			return 0.0, fmt.Errorf("Invalid length %d", len(s))
-		}
-
-		n, err := strconv.ParseFloat(s[0:len(s)-2], 64)
-		if err != nil {
-			return 0.0, err
-		}
-
-		d, err := strconv.ParseFloat(s[len(s)-2:], 64)
-		if err != nil {
-			return 0.0, err
-		}
-
-		return sign * (n + d/64), nil
-
-	case "6": // 128ths
-		if len(s) < 3 {
-			return 0.0, fmt.Errorf("Invalid length %d", len(s))
-		}
-		n, err := strconv.ParseFloat(s[0:len(s)-3], 64)
-		if err != nil {
-			return 0.0, err
-		}
-
-		d, err := strconv.ParseFloat(s[len(s)-3:], 64)
-		if err != nil {
-			return 0.0, err
-		}
-
-		return sign * (n + d/128), nil
-
-	case "7": // 256ths
-		if len(s) < 3 {
-			return 0.0, fmt.Errorf("Invalid length %d", len(s))
-		}
-
-		n, err := strconv.ParseFloat(s[0:len(s)-3], 64)
-		if err != nil {
-			return 0.0, err
-		}
-
-		d, err := strconv.ParseFloat(s[len(s)-3:], 64)
+	switch bc {
+	case '2': // 8ths
+		digits, denom = 1, 8
+	case '3': // 16ths
+		digits, denom = 2, 16
+	case '4': // 32nds
+		digits, denom = 2, 32
+	case '5': // 64th
+		digits, denom = 2, 64
+	case '6': // 128ths
+		digits, denom = 3, 128
+	case '7': // 256ths
+		digits, denom = 3, 256
+	case '8', '9', 'A', 'B', 'C', 'D', 'E', 'F':
+		f, err := parseUint(b)
 		if err != nil {
 			return 0.0, err
 		}
 
-		return sign * (n + d/256), nil
-
-	case "8": // 0s
-		f, err := strconv.Atoi(s)
-		if err != nil {
-			return 0.0, err
+		i := int(bc - '8')
+		if bc >= 'A' {
+			i = int(bc-'A') + 2
 		}
-		return sign * float64(f) / 1.0, nil
 
-	case "9": // 1/10
-		f, err := strconv.Atoi(s)
-		if err != nil {
-			return 0.0, err
-		}
+		return sign * float64(f) / decimalDivisors[i], nil
+	default:
+		return 0.0, nil
+	}
 
-		return sign * float64(f) / 10.0, nil
+	if len(b) <= digits {
+		return 0.0, fmt.Errorf("Invalid length %d", len(b))
+	}
 
-	case "A": // 1/100
-		f, err := strconv.Atoi(s)
-		if err != nil {
-			return 0.0, err
-		}
+	n, err := parseUint(b[:len(b)-digits])
+	if err != nil {
+		return 0.0, err
+	}
 
-		return sign * float64(f) / 100.0, nil
+	d, err := parseUint(b[len(b)-digits:])
+	if err != nil {
+		return 0.0, err
+	}
 
-	case "B": // 1/1000
-		f, err := strconv.Atoi(s)
-		if err != nil {
-			return 0.0, err
-		}
+	return sign * (float64(n) + float64(d)/denom), nil
+}
 
-		return sign * float64(f) / 1000.0, nil
+// TickSize returns the price change of one tick, given a base code and the
+// tick increment in units of that base code.
+func TickSize(baseCode string, tickIncrement int) float64 {
+	if len(baseCode) == 0 {
+		return 0.0
+	}
 
-	case "C":
-		f, err := strconv.Atoi(s)
-		if err != nil {
-			return 0.0, err
-		}
+	switch bc := baseCode[0]; bc {
+	case '2':
+		return float64(tickIncrement) / 8
+	case '3':
+		return float64(tickIncrement) / 16
+	case '4':
+		return float64(tickIncrement) / 32
+	case '5':
+		return float64(tickIncrement) / 64
+	case '6':
+		return float64(tickIncrement) / 128
+	case '7':
+		return float64(tickIncrement) / 256
+	case '8', '9':
+		return float64(tickIncrement) / decimalDivisors[bc-'8']
+	case 'A', 'B', 'C', 'D', 'E', 'F':
+		return float64(tickIncrement) / decimalDivisors[bc-'A'+2]
+	}
 
-		return sign * float64(f) / 10000.0, nil
+	return 0.0
+}
 
-	case "D":
-		f, err := strconv.Atoi(s)
-		if err != nil {
-			return 0.0, err
-		}
+// parseUint reads an unsigned decimal integer of up to 18 digits.
+func parseUint(b []byte) (int64, error) {
+	if len(b) == 0 {
+		return 0, errEmptyNumber
+	}
 
-		return sign * float64(f) / 100000.0, nil
+	if len(b) > 18 {
+		return 0, errInvalidNumber
+	}
 
-	case "E":
-		f, err := strconv.Atoi(s)
-		if err != nil {
-			return 0.0, err
+	var n int64
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return 0, errInvalidNumber
 		}
+		n = n*10 + int64(c-'0')
+	}
 
-		return sign * float64(f) / 1000000.0, nil
-
-	case "F":
-		f, err := strconv.Atoi(s)
-		if err != nil {
-			return 0.0, err
-		}
+	return n, nil
+}
 
-		return sign * float64(f) / 10000000.0, nil
+// parseInt reads a signed decimal integer of up to 18 digits.
+func parseInt(b []byte) (int64, error) {
+	if len(b) > 0 && b[0] == '-' {
+		n, err := parseUint(b[1:])
+		return -n, err
 	}
 
-	return 0.0, nil
+	return parseUint(b)
 }